@@ -0,0 +1,280 @@
+// Package app holds the deployment flow shared by the WireGuard and OpenVPN
+// binaries. Each binary only supplies a utils.Profile.
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"azcommon/utils"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+// stringList is a flag.Value that collects every occurrence of a flag
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// configFlags are the --config and --set flags shared by every command
+type configFlags struct {
+	files     stringList
+	overrides stringList
+}
+
+func (c *configFlags) register(fs *flag.FlagSet) {
+	fs.Var(&c.files, "config", "YAML or JSON config file layered over .env (repeatable)")
+	fs.Var(&c.overrides, "set", "Override a single setting as KEY=VALUE, e.g. VM_NAME=vpn01 (repeatable)")
+}
+
+// load reads and validates the configuration, exiting on any problem
+func (c *configFlags) load() *utils.Config {
+	utils.InfoLogger.Println("Loading configuration")
+	cfg, err := utils.LoadConfig(c.files, c.overrides)
+	utils.LogAndExit(err, "Error loading configuration")
+	utils.LogAndExit(cfg.Validate(), "Configuration error")
+	return cfg
+}
+
+// loadSubscription reads the configuration like load, but only validates
+// the subscription and authentication settings
+func (c *configFlags) loadSubscription() *utils.Config {
+	utils.InfoLogger.Println("Loading configuration")
+	cfg, err := utils.LoadConfig(c.files, c.overrides)
+	utils.LogAndExit(err, "Error loading configuration")
+	utils.LogAndExit(cfg.ValidateSubscription(), "Configuration error")
+	return cfg
+}
+
+// loadBilling reads the configuration like load, but only validates what
+// the cost commands need, see utils.Config.ValidateBilling
+func (c *configFlags) loadBilling(withBudget bool) *utils.Config {
+	utils.InfoLogger.Println("Loading configuration")
+	cfg, err := utils.LoadConfig(c.files, c.overrides)
+	utils.LogAndExit(err, "Error loading configuration")
+	utils.LogAndExit(cfg.ValidateBilling(withBudget), "Configuration error")
+	return cfg
+}
+
+// Run parses the command line and runs the requested command for the VM
+// described by profile. Without a command, or with only flags, it deploys as
+// earlier versions did.
+func Run(profile utils.Profile) {
+	args := os.Args[1:]
+	if len(args) == 0 || (strings.HasPrefix(args[0], "-") && !isHelpFlag(args[0])) {
+		args = append([]string{"deploy"}, args...)
+	}
+	execute(commands(profile), nil, args, profile.LogPrefix)
+	utils.CloseLogger()
+}
+
+// deployCommand creates the resource group and everything in it
+func deployCommand(profile utils.Profile) *command {
+	return &command{
+		name:    "deploy",
+		summary: fmt.Sprintf("Create the %s VM and the network around it", profile.Name),
+		usage:   "[--recreate | --force-delete | --resume] [--rollback-on-failure]",
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			o := deployOptions{}
+			fs.BoolVar(&o.forceDelete, "force-delete", false, "Force delete existing resource group without prompting")
+			fs.BoolVar(&o.recreate, "recreate", false, "Delete and recreate the resource group if it exists")
+			fs.BoolVar(&o.resume, "resume", false, "Continue a failed deployment, skipping the steps recorded as completed")
+			fs.BoolVar(&o.rollbackOnFailure, "rollback-on-failure", false, "Delete every resource created by this run if a step fails")
+			o.cf.register(fs)
+			return func(args []string) {
+				runDeploy(profile, o)
+			}
+		},
+	}
+}
+
+// deployOptions are the flags of the deploy command
+type deployOptions struct {
+	forceDelete       bool
+	recreate          bool
+	resume            bool
+	rollbackOnFailure bool
+	cf                configFlags
+}
+
+// runDeploy deploys the VM described by profile
+func runDeploy(profile utils.Profile, o deployOptions) {
+	utils.InfoLogger.Printf("Starting %s Azure VM deployment", profile.Name)
+	cfg := o.cf.load()
+	utils.LogAndExit(cfg.ValidateRules(profile), "Invalid network security rules")
+
+	ctx := context.Background()
+	utils.LogAndExit(cfg.ResolveManagementSources(ctx, cfg.IPResolver()), "Failed to resolve MANAGEMENT_SOURCES")
+	rules, err := utils.NetSecRules(cfg, profile)
+	utils.LogAndExit(err, "Invalid network security rules")
+	customData, err := utils.CustomData(cfg, profile, false)
+	utils.LogAndExit(err, "Invalid cloud-init")
+	cred, err := utils.NewCredential(cfg)
+	utils.LogAndExit(err, "Failed to get credentials")
+
+	subscriptionID := cfg.SubscriptionID
+	resourceGroupName := cfg.ResourceGroupName
+	location := cfg.Location
+	utils.InfoLogger.Printf("Using resource group: %s in location: %s", resourceGroupName, location)
+
+	state, err := utils.LoadState(resourceGroupName)
+	utils.LogAndExit(err, "Failed to load deployment state")
+
+	groupsClient, err := armresources.NewResourceGroupsClient(subscriptionID, cred, nil)
+	utils.LogAndExit(err, "Failed to create resource groups client")
+
+	// Check if the resource group exists
+	utils.InfoLogger.Printf("Checking if resource group %s exists", resourceGroupName)
+	checkRG, err := groupsClient.Get(ctx, resourceGroupName, nil)
+	groupExisted := err == nil
+	if err == nil {
+		utils.InfoLogger.Printf("Resource group %q exists", *checkRG.Name)
+		if o.forceDelete || o.recreate {
+			utils.LogAndExit(utils.DeleteResourceGroup(ctx, cred, cfg), "Failed to delete resource group")
+			utils.LogAndExit(state.Remove(), "Failed to clear deployment state")
+			groupExisted = false
+
+			if !o.recreate {
+				utils.InfoLogger.Println("Resource group deleted, exiting as requested")
+				return
+			}
+
+			// Deletion can finish before the name is free again, wait until
+			// Azure no longer reports the group instead of a fixed delay
+			utils.LogAndExit(
+				utils.WaitForResourceGroupDeletion(ctx, cred, cfg, cfg.DeleteWaitOptions()),
+				"Resource group deletion did not propagate",
+			)
+			utils.InfoLogger.Println("Starting RG Creation")
+		} else if o.resume && state.Exists() {
+			utils.InfoLogger.Printf("Resuming deployment, %d steps recorded in %s", len(state.Steps), utils.StatePath(resourceGroupName))
+			if state.FailedStep != "" {
+				utils.InfoLogger.Printf("Last run failed at step %s: %s", state.FailedStep, state.LastError)
+			}
+		} else {
+			utils.LogAndExit(
+				fmt.Errorf("resource group %q already exists", resourceGroupName),
+				"Use --force-delete to delete, --recreate to delete and recreate or --resume to continue a failed deployment",
+			)
+		}
+	} else if state.Exists() {
+		// Nothing recorded can be reused once the resource group is gone
+		utils.InfoLogger.Printf("Resource group %q does not exist, discarding stale deployment state", resourceGroupName)
+		utils.LogAndExit(state.Remove(), "Failed to clear deployment state")
+	}
+
+	d := &deployment{
+		ctx:        ctx,
+		cred:       cred,
+		cfg:        cfg,
+		profile:    profile,
+		state:      state,
+		resume:     o.resume,
+		rules:      rules,
+		customData: customData,
+
+		rollbackOnFailure: o.rollbackOnFailure,
+		groupExisted:      groupExisted,
+	}
+	d.run(groupsClient)
+	utils.InfoLogger.Printf("%s Azure VM deployment completed successfully", profile.Name)
+
+	publicIP, err := utils.GetPublicIPAddress(ctx, cred, cfg)
+	utils.LogAndExit(err, "Failed to look up the public IP address")
+	fmt.Printf("%s VM can be accessed by ssh %s\n", profile.Name, strings.Join(sshArguments(cfg, "", publicIP, nil), " "))
+	fmt.Printf("or simply run: %s ssh\n", programName())
+
+	port := strconv.Itoa(cfg.VPNPort(profile))
+	endpoints := []string{net.JoinHostPort(publicIP, port)}
+	if cfg.DualStack() {
+		publicIPv6, err := utils.GetPublicIPv6Address(ctx, cred, cfg)
+		utils.LogAndExit(err, "Failed to look up the IPv6 public IP address")
+		endpoints = append(endpoints, net.JoinHostPort(publicIPv6, port))
+	}
+	fmt.Printf("%s endpoint: %s (%s)\n", profile.Name, strings.Join(endpoints, ", "), strings.ToLower(profile.VPNProtocol))
+	if profile.ServerInfo != nil {
+		info, err := profile.ServerInfo(cfg, profile)
+		utils.LogAndExit(err, "Failed to describe the server")
+		fmt.Print(info)
+	}
+}
+
+// planCommand prints the resources a deployment would create
+func planCommand(profile utils.Profile) *command {
+	return &command{
+		name:    "plan",
+		summary: "Print every resource that would be created without touching Azure",
+		usage:   "[--out FILE] [--prices FILE | --currency CODE] [--egress-gb N] [--no-estimate]",
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			out := fs.String("out", "", "Write the JSON plan to this file instead of stdout")
+			prices := fs.String("prices", "", "Price the estimate from this local JSON price sheet instead of the Azure Retail Prices API")
+			currency := fs.String("currency", "", "Currency of the estimate when using the Retail Prices API, e.g. EUR (default USD)")
+			egressGB := fs.Float64("egress-gb", utils.DefaultEgressGB, "Monthly outbound traffic in GB assumed by the estimate")
+			noEstimate := fs.Bool("no-estimate", false, "Skip the monthly cost estimate")
+			var cf configFlags
+			cf.register(fs)
+			return func(args []string) {
+				cfg := cf.load()
+				utils.LogAndExit(cfg.ValidateRules(profile), "Invalid network security rules")
+				utils.LogAndExit(cfg.ResolveManagementSources(context.Background(), cfg.IPResolver()), "Failed to resolve MANAGEMENT_SOURCES")
+				plan, err := utils.BuildPlan(cfg, profile)
+				utils.LogAndExit(err, "Failed to build the plan")
+				if !*noEstimate {
+					plan.Estimate = estimate(cfg, *prices, *currency, *egressGB)
+				}
+				utils.LogAndExit(writePlan(plan, *out), "Failed to write plan")
+			}
+		},
+	}
+}
+
+// estimate prices the deployment from the local price sheet at path, or the
+// Retail Prices API when path is empty. A failed estimate is logged and left
+// out, the plan itself does not depend on it.
+func estimate(cfg *utils.Config, path, currency string, egressGB float64) *utils.CostEstimate {
+	var source utils.PriceSource = &utils.RetailPriceSource{Currency: currency}
+	if path != "" {
+		sheet, err := utils.LoadPriceSheet(path)
+		utils.LogAndExit(err, "Failed to load price sheet")
+		source = sheet
+	}
+
+	utils.InfoLogger.Println("Estimating monthly cost")
+	estimate, err := utils.EstimateCost(context.Background(), source, cfg, egressGB)
+	if err != nil {
+		utils.ErrorLogger.Printf("Failed to estimate the monthly cost, use --prices for offline pricing: %v", err)
+		return nil
+	}
+	return estimate
+}
+
+// writePlan prints the plan for review and writes its JSON form to path, or
+// to stdout when path is empty
+func writePlan(plan *utils.Plan, path string) error {
+	plan.WriteText(os.Stdout)
+	if path == "" {
+		fmt.Println()
+		return plan.WriteJSON(os.Stdout)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := plan.WriteJSON(file); err != nil {
+		return err
+	}
+	fmt.Printf("\nJSON plan written to %s\n", path)
+	return nil
+}
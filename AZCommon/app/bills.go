@@ -0,0 +1,146 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"azcommon/utils"
+)
+
+// billsCommand reports the cost of the resource group
+func billsCommand() *command {
+	return &command{
+		name:        "bills",
+		summary:     "Show the cost of the resource group over a period",
+		usage:       "[--timeframe NAME | --from DATE [--to DATE]] [--group-by DIMENSION] [--compare] [--csv FILE] [--json FILE]",
+		subcommands: []*command{billsBudgetCommand()},
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			timeframe := fs.String("timeframe", utils.TimeframeMonthToDate, "Preset period: "+strings.Join(utils.TimeframeValues(), ", "))
+			from := fs.String("from", "", "First day of a custom period, as YYYY-MM-DD")
+			to := fs.String("to", "", "Last day of a custom period, as YYYY-MM-DD, defaults to today")
+			groupBy := fs.String("group-by", utils.GroupByResourceType, "Group costs by "+strings.Join(utils.GroupByValues(), ", "))
+			compare := fs.Bool("compare", false, "Show the previous period next to this one with the change per group")
+			csvPath := fs.String("csv", "", "Write daily cost records per resource and meter category to this CSV file")
+			jsonPath := fs.String("json", "", "Write daily cost records per resource and meter category to this JSON file")
+			var cf configFlags
+			cf.register(fs)
+			return func(args []string) {
+				query, err := costQuery(fs, *timeframe, *from, *to, time.Now())
+				utils.LogAndExit(err, "Invalid period")
+				query.GroupBy = *groupBy
+
+				cfg := cf.loadBilling(false)
+				cred, err := utils.NewCredential(cfg)
+				utils.LogAndExit(err, "Failed to get credentials")
+
+				ctx := context.Background()
+				report, err := utils.GetBillingInfo(ctx, cred, cfg, query)
+				utils.LogAndExit(err, "Failed to get billing information")
+
+				if *compare {
+					previous := query
+					previous.From, previous.To = utils.PreviousPeriod(query.From, query.To)
+					previousReport, err := utils.GetBillingInfo(ctx, cred, cfg, previous)
+					utils.LogAndExit(err, "Failed to get billing information for the previous period")
+					utils.CompareCosts(report, previousReport).WriteText(os.Stdout)
+				} else {
+					report.WriteText(os.Stdout)
+				}
+
+				if *csvPath == "" && *jsonPath == "" {
+					return
+				}
+				records, err := utils.GetCostRecords(ctx, cred, cfg, query)
+				utils.LogAndExit(err, "Failed to get cost records")
+				if *csvPath != "" {
+					utils.LogAndExit(writeFile(*csvPath, func(w io.Writer) error {
+						return utils.WriteCostRecordsCSV(w, records)
+					}), "Failed to write CSV export")
+					fmt.Printf("%d cost records written to %s\n", len(records), *csvPath)
+				}
+				if *jsonPath != "" {
+					utils.LogAndExit(writeFile(*jsonPath, func(w io.Writer) error {
+						return utils.WriteCostRecordsJSON(w, records)
+					}), "Failed to write JSON export")
+					fmt.Printf("%d cost records written to %s\n", len(records), *jsonPath)
+				}
+			}
+		},
+	}
+}
+
+// billsBudgetCommand shows the current spend against the configured budget
+func billsBudgetCommand() *command {
+	return &command{
+		name:    "budget",
+		summary: "Show the current spend against the resource group's budget",
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			var cf configFlags
+			cf.register(fs)
+			return func(args []string) {
+				cfg := cf.loadBilling(true)
+				cred, err := utils.NewCredential(cfg)
+				utils.LogAndExit(err, "Failed to get credentials")
+
+				budget, err := utils.GetBudget(context.Background(), cred, cfg)
+				utils.LogAndExit(err, "Failed to get budget")
+				utils.WriteBudgetStatus(os.Stdout, budget)
+			}
+		},
+	}
+}
+
+// costQuery builds the period of a cost query from either the preset
+// timeframe or the custom --from/--to dates
+func costQuery(fs *flag.FlagSet, timeframe, from, to string, now time.Time) (utils.CostQuery, error) {
+	timeframeSet := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "timeframe" {
+			timeframeSet = true
+		}
+	})
+
+	if from == "" {
+		if to != "" {
+			return utils.CostQuery{}, fmt.Errorf("--to needs --from")
+		}
+		start, end, err := utils.BillingPeriod(timeframe, now)
+		return utils.CostQuery{From: start, To: end}, err
+	}
+	if timeframeSet {
+		return utils.CostQuery{}, fmt.Errorf("use either --timeframe or --from/--to, not both")
+	}
+
+	start, err := time.Parse(time.DateOnly, from)
+	if err != nil {
+		return utils.CostQuery{}, fmt.Errorf("--from %q is not a date like 2025-01-31", from)
+	}
+	end := now.UTC().Truncate(24 * time.Hour)
+	if to != "" {
+		if end, err = time.Parse(time.DateOnly, to); err != nil {
+			return utils.CostQuery{}, fmt.Errorf("--to %q is not a date like 2025-01-31", to)
+		}
+	}
+	if end.Before(start) {
+		return utils.CostQuery{}, fmt.Errorf("--to %s is before --from %s", to, from)
+	}
+	return utils.CostQuery{From: start, To: end}, nil
+}
+
+// writeFile creates path and fills it with write
+func writeFile(path string, write func(w io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,231 @@
+package app
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"azcommon/utils"
+)
+
+// command is a node of the command tree. Leaf commands have setup, groups
+// such as "config" only have subcommands. A command with both, such as
+// "bills", runs itself unless a subcommand is named.
+type command struct {
+	name    string
+	summary string
+	// usage is the argument synopsis printed after the command path
+	usage string
+	// setup registers the command's flags and returns the function to run
+	// once they are parsed, which receives the remaining arguments
+	setup       func(fs *flag.FlagSet) func(args []string)
+	subcommands []*command
+	// args lists fixed values offered by shell completion for positional
+	// arguments
+	args []string
+	// quiet commands print output meant for other programs, they do not
+	// write a log file and only log errors, to stderr
+	quiet bool
+}
+
+// commands returns the command tree shared by the WireGuard and OpenVPN
+// binaries. Help and completion are generated from it.
+func commands(profile utils.Profile) []*command {
+	var cmds []*command
+	cmds = []*command{
+		deployCommand(profile),
+		destroyCommand(profile),
+		planCommand(profile),
+		statusCommand(profile),
+		listCommand(),
+		billsCommand(),
+		{
+			name:        "rules",
+			summary:     "Inspect, reconcile and restrict the network security rules",
+			subcommands: []*command{rulesListCommand(), rulesDiffCommand(profile), rulesSyncCommand(profile), rulesLockdownCommand(profile)},
+		},
+		peersCommand(profile),
+		sshCommand(profile),
+		{
+			name:        "config",
+			summary:     "Work with the configuration",
+			subcommands: []*command{configValidateCommand(profile), configCloudInitCommand(profile)},
+		},
+		completionCommand(&cmds),
+		helpCommand(&cmds),
+	}
+	return cmds
+}
+
+// programName is the name the binary was invoked as
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
+
+// find returns the command called name, or nil
+func find(cmds []*command, name string) *command {
+	for _, cmd := range cmds {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// newFlagSet creates the flag set of a leaf command, with help text built
+// from the command's usage and summary
+func (c *command) newFlagSet(path []string) *flag.FlagSet {
+	fs := flag.NewFlagSet(strings.Join(path, " "), flag.ExitOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s %s", programName(), strings.Join(path, " "))
+		if c.usage != "" {
+			fmt.Fprintf(out, " %s", c.usage)
+		}
+		fmt.Fprintf(out, "\n\n%s\n", c.summary)
+		if len(c.subcommands) > 0 {
+			fmt.Fprintln(out, "\nSubcommands:")
+			for _, sub := range c.subcommands {
+				fmt.Fprintf(out, "  %-12s %s\n", sub.name, sub.summary)
+			}
+		}
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintln(out, "\nFlags:")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// execute finds the command named by args in the tree, initializes logging
+// with logPrefix and runs it
+func execute(cmds []*command, path, args []string, logPrefix string) {
+	if len(args) == 0 || isHelpFlag(args[0]) {
+		printCommands(os.Stdout, cmds, path)
+		return
+	}
+
+	cmd := find(cmds, args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", strings.Join(append(path, args[0]), " "))
+		printCommands(os.Stderr, cmds, path)
+		os.Exit(2)
+	}
+	path = append(path, cmd.name)
+
+	if len(cmd.subcommands) > 0 && (cmd.setup == nil || len(args) > 1 && find(cmd.subcommands, args[1]) != nil) {
+		execute(cmd.subcommands, path, args[1:], logPrefix)
+		return
+	}
+
+	fs := cmd.newFlagSet(path)
+	run := cmd.setup(fs)
+	fs.Parse(args[1:])
+
+	if cmd.quiet {
+		utils.InitQuietLogger()
+	} else if err := utils.InitLogger(logPrefix); err != nil {
+		fmt.Printf("Failed to initialize logging: %v\n", err)
+		os.Exit(1)
+	}
+	run(fs.Args())
+}
+
+func isHelpFlag(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+// printCommands lists the commands available at path
+func printCommands(w io.Writer, cmds []*command, path []string) {
+	prefix := strings.TrimSpace(programName() + " " + strings.Join(path, " "))
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", prefix)
+	for _, cmd := range cmds {
+		fmt.Fprintf(w, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nRun \"%s <command> --help\" for the flags of a command.\n", prefix)
+}
+
+// helpCommand prints the command list or the help of a single command
+func helpCommand(cmds *[]*command) *command {
+	return &command{
+		name:    "help",
+		summary: "Show help for a command",
+		usage:   "[command...]",
+		quiet:   true,
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			return func(args []string) {
+				level, path := *cmds, []string{}
+				for i, name := range args {
+					cmd := find(level, name)
+					if cmd == nil {
+						utils.LogAndExit(fmt.Errorf("unknown command %q", strings.Join(append(path, name), " ")), "Invalid arguments")
+					}
+					path = append(path, cmd.name)
+					if cmd.setup != nil && (len(cmd.subcommands) == 0 || i == len(args)-1) {
+						fs := cmd.newFlagSet(path)
+						cmd.setup(fs)
+						fs.SetOutput(os.Stdout)
+						fs.Usage()
+						return
+					}
+					level = cmd.subcommands
+				}
+				printCommands(os.Stdout, level, path)
+			}
+		},
+	}
+}
+
+// configValidateCommand loads and validates the configuration without
+// contacting Azure
+func configValidateCommand(profile utils.Profile) *command {
+	return &command{
+		name:    "validate",
+		summary: "Check the configuration and report every problem at once",
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			var cf configFlags
+			cf.register(fs)
+			return func(args []string) {
+				cfg := cf.load()
+				utils.LogAndExit(cfg.ValidateRules(profile), "Invalid network security rules")
+				fmt.Printf("Configuration is valid: VM %s in resource group %s (%s)\n", cfg.VMName, cfg.ResourceGroupName, cfg.Location)
+			}
+		},
+	}
+}
+
+// configCloudInitCommand prints the cloud-init document deploy passes to
+// the VM
+func configCloudInitCommand(profile utils.Profile) *command {
+	return &command{
+		name:    "cloud-init",
+		summary: "Print the cloud-init document passed to the VM as custom data",
+		usage:   "[--out FILE]",
+		quiet:   true,
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			var cf configFlags
+			cf.register(fs)
+			out := fs.String("out", "", "Write the document to this file instead of stdout")
+			return func(args []string) {
+				cfg := cf.load()
+				doc, err := cfg.CloudInit(profile, false)
+				utils.LogAndExit(err, "Invalid cloud-init")
+				if doc == nil {
+					fmt.Fprintln(os.Stderr, "No cloud-init: set CLOUD_INIT_FILE to pass one to the VM")
+					return
+				}
+				if *out == "" {
+					os.Stdout.Write(doc)
+					return
+				}
+				utils.LogAndExit(os.WriteFile(*out, doc, 0o600), "Failed to write the cloud-init document")
+				fmt.Fprintf(os.Stderr, "Cloud-init written to %s (%d bytes)\n", *out, len(doc))
+			}
+		},
+	}
+}
@@ -0,0 +1,173 @@
+package app
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"azcommon/utils"
+)
+
+// completionWord is a word offered at some point of the command line
+type completionWord struct {
+	word        string
+	description string
+}
+
+// completionNode lists what can follow a command path: subcommands for
+// groups, flags for leaf commands
+type completionNode struct {
+	path  string
+	words []completionWord
+}
+
+// completionNodes walks the command tree, starting with the top level whose
+// path is empty
+func completionNodes(cmds []*command) []completionNode {
+	var nodes []completionNode
+	var walk func(path string, cmds []*command)
+	walk = func(path string, cmds []*command) {
+		node := completionNode{path: path}
+		for _, cmd := range cmds {
+			node.words = append(node.words, completionWord{cmd.name, cmd.summary})
+		}
+		nodes = append(nodes, node)
+
+		for _, cmd := range cmds {
+			cmdPath := strings.TrimSpace(path + " " + cmd.name)
+			idx := len(nodes)
+			if len(cmd.subcommands) > 0 {
+				walk(cmdPath, cmd.subcommands)
+			} else {
+				nodes = append(nodes, completionNode{path: cmdPath})
+			}
+			if cmd.setup == nil {
+				continue
+			}
+
+			// Commands that run themselves also offer their arguments and flags
+			leaf := &nodes[idx]
+			fs := flag.NewFlagSet(cmdPath, flag.ContinueOnError)
+			cmd.setup(fs)
+			for _, arg := range cmd.args {
+				leaf.words = append(leaf.words, completionWord{arg, cmd.summary})
+			}
+			fs.VisitAll(func(f *flag.Flag) {
+				leaf.words = append(leaf.words, completionWord{"--" + f.Name, f.Usage})
+			})
+		}
+	}
+	walk("", cmds)
+	return nodes
+}
+
+// completionCommand prints a bash or zsh completion script for the binary
+func completionCommand(cmds *[]*command) *command {
+	return &command{
+		name:    "completion",
+		summary: "Print a bash or zsh completion script",
+		usage:   "(bash | zsh)",
+		args:    []string{"bash", "zsh"},
+		quiet:   true,
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			return func(args []string) {
+				if len(args) != 1 {
+					fs.Usage()
+					os.Exit(2)
+				}
+				nodes := completionNodes(*cmds)
+				switch args[0] {
+				case "bash":
+					writeBashCompletion(os.Stdout, programName(), nodes)
+				case "zsh":
+					writeZshCompletion(os.Stdout, programName(), nodes)
+				default:
+					utils.LogAndExit(fmt.Errorf("unsupported shell %q, expected bash or zsh", args[0]), "Invalid arguments")
+				}
+			}
+		},
+	}
+}
+
+var nonIdentifier = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// completionFunc is the shell function name used for a binary
+func completionFunc(program string) string {
+	return "_" + nonIdentifier.ReplaceAllString(program, "_")
+}
+
+// commandPaths returns the case pattern matching every non-empty path, used
+// to tell command words apart from flag values
+func commandPaths(nodes []completionNode) string {
+	var paths []string
+	for _, node := range nodes {
+		if node.path != "" {
+			paths = append(paths, fmt.Sprintf("%q", node.path))
+		}
+	}
+	return strings.Join(paths, "|")
+}
+
+func writeBashCompletion(w io.Writer, program string, nodes []completionNode) {
+	fn := completionFunc(program)
+	fmt.Fprintf(w, "# bash completion for %s, load with: source <(%s completion bash)\n", program, program)
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprintln(w, `    local cur="${COMP_WORDS[COMP_CWORD]}" cmdpath="" next words i`)
+	fmt.Fprintln(w, `    for ((i = 1; i < COMP_CWORD; i++)); do`)
+	fmt.Fprintln(w, `        next="${cmdpath:+$cmdpath }${COMP_WORDS[i]}"`)
+	fmt.Fprintln(w, `        case "$next" in`)
+	fmt.Fprintf(w, "            %s) cmdpath=\"$next\" ;;\n", commandPaths(nodes))
+	fmt.Fprintln(w, `        esac`)
+	fmt.Fprintln(w, `    done`)
+	fmt.Fprintln(w, `    case "$cmdpath" in`)
+	for _, node := range nodes {
+		var words []string
+		for _, word := range node.words {
+			words = append(words, word.word)
+		}
+		fmt.Fprintf(w, "        %q) words=%q ;;\n", node.path, strings.Join(words, " "))
+	}
+	fmt.Fprintln(w, `    esac`)
+	fmt.Fprintln(w, `    COMPREPLY=($(compgen -W "$words" -- "$cur"))`)
+	fmt.Fprintln(w, "}")
+	fmt.Fprintf(w, "complete -o default -F %s %s\n", fn, program)
+}
+
+func writeZshCompletion(w io.Writer, program string, nodes []completionNode) {
+	fn := completionFunc(program)
+	fmt.Fprintf(w, "#compdef %s\n", program)
+	fmt.Fprintf(w, "# zsh completion for %s, load with: source <(%s completion zsh)\n", program, program)
+	fmt.Fprintf(w, "%s() {\n", fn)
+	fmt.Fprintln(w, `    local cmdpath="" next i`)
+	fmt.Fprintln(w, `    local -a opts`)
+	fmt.Fprintln(w, `    for ((i = 2; i < CURRENT; i++)); do`)
+	fmt.Fprintln(w, `        next="${cmdpath:+$cmdpath }${words[i]}"`)
+	fmt.Fprintln(w, `        case "$next" in`)
+	fmt.Fprintf(w, "            (%s) cmdpath=\"$next\" ;;\n", commandPaths(nodes))
+	fmt.Fprintln(w, `        esac`)
+	fmt.Fprintln(w, `    done`)
+	fmt.Fprintln(w, `    case "$cmdpath" in`)
+	for _, node := range nodes {
+		var opts []string
+		for _, word := range node.words {
+			opts = append(opts, zshQuote(word.word+":"+word.description))
+		}
+		fmt.Fprintf(w, "        (%q) opts=(%s) ;;\n", node.path, strings.Join(opts, " "))
+	}
+	fmt.Fprintln(w, `    esac`)
+	fmt.Fprintln(w, `    _describe 'command or flag' opts`)
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, `if [[ $zsh_eval_context[-1] == loadautofunc ]]; then`)
+	fmt.Fprintf(w, "    %s \"$@\"\n", fn)
+	fmt.Fprintln(w, "else")
+	fmt.Fprintf(w, "    compdef %s %s\n", fn, program)
+	fmt.Fprintln(w, "fi")
+}
+
+// zshQuote single quotes s for zsh
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
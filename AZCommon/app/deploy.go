@@ -0,0 +1,276 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"azcommon/utils"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+// Deployment step names, as recorded in the state file
+const (
+	stepResourceGroup = "resourceGroup"
+	stepBudget        = "budget"
+	stepVnet          = "vnet"
+	stepSubnet        = "subnet"
+	stepPublicIP      = "publicIP"
+	stepPublicIPv6    = "publicIPv6"
+	stepNsg           = "nsg"
+	stepNIC           = "nic"
+	stepVM            = "vm"
+)
+
+// steps lists the deployment steps in the order they run
+var steps = []string{stepResourceGroup, stepBudget, stepVnet, stepNsg, stepSubnet, stepPublicIP, stepPublicIPv6, stepNIC, stepVM}
+
+// deployment runs the deployment steps in order, recording each completed
+// step in the state file so a failed run can be resumed
+type deployment struct {
+	ctx     context.Context
+	cred    azcore.TokenCredential
+	cfg     *utils.Config
+	profile utils.Profile
+	state   *utils.DeploymentState
+	resume  bool
+
+	// rules and customData are built before anything is created, so that
+	// invalid rules or an oversized cloud-init fail without leaving
+	// resources behind
+	rules      []armnetwork.SecurityRule
+	customData string
+
+	// rollbackOnFailure deletes the resources created by this run when a
+	// step fails, instead of leaving them for --resume
+	rollbackOnFailure bool
+	groupExisted      bool
+	createdGroup      bool
+	created           []createdResource
+}
+
+// step runs a single deployment step unless a resumed run already completed
+// it with the same input, and returns the ID of the resource it produced
+func (d *deployment) step(name string, input any, run func() (string, error)) string {
+	hash := utils.HashInput(input)
+	if d.resume {
+		if done, ok := d.state.Completed(name, hash); ok {
+			utils.InfoLogger.Printf("Skipping step %s, already completed: %s", name, done.ResourceID)
+			return done.ResourceID
+		}
+		if _, ok := d.state.Steps[name]; ok {
+			utils.InfoLogger.Printf("Input for step %s changed since the last run, applying it again", name)
+		}
+	}
+
+	d.track(name)
+	id, err := run()
+	if err != nil {
+		d.fail(name, err)
+	}
+	utils.LogAndExit(d.state.Record(name, id, hash), "Failed to save deployment state")
+	return id
+}
+
+// fail records the failed step and exits. The deployment can then be
+// continued with --resume, unless rollback on failure is enabled in which
+// case everything this run created is deleted first.
+func (d *deployment) fail(name string, err error) {
+	utils.ErrorLogger.Printf("Deployment failed at step %s: %v", name, err)
+	if saveErr := d.state.Fail(name, err); saveErr != nil {
+		utils.ErrorLogger.Printf("Failed to save deployment state: %v", saveErr)
+	}
+
+	if d.rollbackOnFailure {
+		d.rollback()
+	} else {
+		utils.ErrorLogger.Printf("State saved to %s", utils.StatePath(d.cfg.ResourceGroupName))
+		utils.ErrorLogger.Println("Fix the problem and rerun with --resume to continue from this step")
+	}
+	utils.LogAndExit(err, fmt.Sprintf("Step %s failed", name))
+}
+
+// run creates every resource of the deployment in dependency order
+func (d *deployment) run(groupsClient *armresources.ResourceGroupsClient) {
+	ctx, cred, cfg := d.ctx, d.cred, d.cfg
+
+	rgParams := armresources.ResourceGroup{
+		Location: &cfg.Location,
+		Name:     &cfg.ResourceGroupName,
+	}
+	d.step(stepResourceGroup, rgParams, func() (string, error) {
+		utils.InfoLogger.Printf("Creating new resource group: %s", cfg.ResourceGroupName)
+		var rgResponse armresources.ResourceGroupsClientCreateOrUpdateResponse
+		// Right after a deletion Azure can still reject the name with a
+		// conflict, keep retrying until it accepts the group
+		err := utils.WaitFor(ctx, "resource group name to become available", cfg.DeleteWaitOptions(), func(ctx context.Context) (bool, error) {
+			var err error
+			rgResponse, err = groupsClient.CreateOrUpdate(ctx, cfg.ResourceGroupName, rgParams, nil)
+			if utils.IsConflict(err) {
+				utils.InfoLogger.Printf("Resource group %s is still being deleted: %v", cfg.ResourceGroupName, err)
+				return false, nil
+			}
+			return err == nil, err
+		})
+		if err != nil {
+			return "", err
+		}
+		utils.InfoLogger.Printf("Resource group %q created in %q", *rgResponse.Name, *rgResponse.Location)
+		return *rgResponse.ID, nil
+	})
+
+	// Budget alerts go in first, so a deployment that fails half way is
+	// already covered
+	budget, err := cfg.Budget()
+	utils.LogAndExit(err, "Invalid budget settings")
+	if budget != nil {
+		d.step(stepBudget, budget, func() (string, error) {
+			result, err := utils.CreateBudget(ctx, cred, cfg)
+			if err != nil {
+				return "", err
+			}
+			return *result.ID, nil
+		})
+	}
+
+	d.step(stepVnet, utils.VnetParams(cfg), func() (string, error) {
+		utils.InfoLogger.Printf("Creating virtual network %s with address prefix %s", cfg.VnetName, cfg.AddressPrefix)
+		vnetResult, err := utils.CreateVnet(ctx, cred, cfg)
+		if err != nil {
+			return "", err
+		}
+		utils.InfoLogger.Printf("Virtual network %q created", *vnetResult.Name)
+		return *vnetResult.ID, nil
+	})
+
+	// Create the Network Security Group (NSG) with all of its rules in one
+	// operation, before the subnet that may reference it
+	rules := d.rules
+	nsgID := d.step(stepNsg, utils.NsgParams(cfg, rules), func() (string, error) {
+		utils.InfoLogger.Printf("Creating network security group: %s", cfg.NSGName)
+		nsgPoller, err := utils.CreateNsg(ctx, cred, cfg, rules)
+		if err != nil {
+			return "", err
+		}
+		nsgResult, err := nsgPoller.PollUntilDone(ctx, nil)
+		if err != nil {
+			return "", err
+		}
+		utils.InfoLogger.Printf("Network Security Group %q created with %d rules", *nsgResult.Name, len(nsgResult.Properties.SecurityRules))
+		return *nsgResult.ID, nil
+	})
+
+	subnetID, publicIPID := d.addresses(nsgID)
+
+	// Dual stack adds an IPv6 public IP next to the IPv4 one
+	var publicIPv6ID string
+	if cfg.DualStack() {
+		publicIPv6ID = d.step(stepPublicIPv6, utils.PublicIPv6Params(cfg), func() (string, error) {
+			publicIPPoller, err := utils.CreatePublicIPv6(ctx, cred, cfg)
+			if err != nil {
+				return "", err
+			}
+			utils.InfoLogger.Println("Waiting for IPv6 public IP creation to complete...")
+			publicIPResult, err := publicIPPoller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{
+				Frequency: 6 * time.Second,
+			})
+			if err != nil {
+				return "", err
+			}
+			utils.InfoLogger.Printf("IPv6 public IP %q created", *publicIPResult.Name)
+			return *publicIPResult.ID, nil
+		})
+	}
+
+	// Create a Network Interface (NIC)
+	nicID := d.step(stepNIC, utils.NICParams(cfg, subnetID, publicIPID, publicIPv6ID, nsgID), func() (string, error) {
+		utils.InfoLogger.Println("Creating network interface")
+		nicPoller, err := utils.CreateNIC(ctx, cred, cfg, &subnetID, &publicIPID, &publicIPv6ID, &nsgID)
+		if err != nil {
+			return "", err
+		}
+		utils.InfoLogger.Println("Waiting for NIC creation to complete...")
+		nicResult, err := nicPoller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{
+			Frequency: 7 * time.Second,
+		})
+		if err != nil {
+			return "", err
+		}
+		utils.InfoLogger.Printf("NIC %q created", *nicResult.Name)
+		return *nicResult.ID, nil
+	})
+
+	// Deploy VM, with the cloud-init document that sets it up on first boot
+	customData := d.customData
+	d.step(stepVM, utils.VMParams(cfg, nicID, customData), func() (string, error) {
+		utils.InfoLogger.Println("Starting virtual machine deployment")
+		vmPoller, err := utils.CreateVM(ctx, cred, cfg, nicID, customData)
+		if err != nil {
+			return "", err
+		}
+		utils.InfoLogger.Println("Waiting for VM creation to complete...")
+		vmResult, err := vmPoller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{
+			Frequency: 7 * time.Second,
+		})
+		if err != nil {
+			return "", err
+		}
+		utils.InfoLogger.Printf("VM %q created successfully", *vmResult.Name)
+		return *vmResult.ID, nil
+	})
+}
+
+// addresses creates the subnet and public IP together, as CreateAddresses
+// starts both at once. When resuming, both are skipped only if both completed.
+func (d *deployment) addresses(nsgID string) (subnetID, publicIPID string) {
+	ctx, cred, cfg := d.ctx, d.cred, d.cfg
+	subnetHash := utils.HashInput(utils.SubnetParams(cfg, nsgID))
+	publicIPHash := utils.HashInput(utils.PublicIPParams(cfg))
+
+	if d.resume {
+		subnetDone, subnetOK := d.state.Completed(stepSubnet, subnetHash)
+		publicIPDone, publicIPOK := d.state.Completed(stepPublicIP, publicIPHash)
+		if subnetOK && publicIPOK {
+			utils.InfoLogger.Println("Skipping subnet and public IP, already completed")
+			return subnetDone.ResourceID, publicIPDone.ResourceID
+		}
+	}
+
+	// Create Subnet and Public IP
+	d.track(stepSubnet)
+	d.track(stepPublicIP)
+	utils.InfoLogger.Println("Creating subnet and public IP address")
+	subnetPoller, publicIPPoller, err := utils.CreateAddresses(ctx, cred, cfg, nsgID)
+	if err != nil {
+		d.fail(stepSubnet, err)
+	}
+
+	// Poll for subnet completion
+	utils.InfoLogger.Println("Waiting for subnet creation to complete...")
+	subnetResult, err := subnetPoller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{
+		Frequency: 6 * time.Second,
+	})
+	if err != nil {
+		d.fail(stepSubnet, err)
+	}
+	utils.InfoLogger.Printf("Virtual subnetwork %q created", *subnetResult.Name)
+	utils.LogAndExit(d.state.Record(stepSubnet, *subnetResult.ID, subnetHash), "Failed to save deployment state")
+
+	// Poll for public IP completion
+	utils.InfoLogger.Println("Waiting for public IP creation to complete...")
+	publicIPResult, err := publicIPPoller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{
+		Frequency: 6 * time.Second,
+	})
+	if err != nil {
+		d.fail(stepPublicIP, err)
+	}
+	utils.InfoLogger.Printf("Public IP %q created\n", *publicIPResult.Name)
+	utils.InfoLogger.Printf("Public IP %q created\n", *publicIPResult.PublicIPAddress.Properties.IPAddress)
+	utils.LogAndExit(d.state.Record(stepPublicIP, *publicIPResult.ID, publicIPHash), "Failed to save deployment state")
+
+	return *subnetResult.ID, *publicIPResult.ID
+}
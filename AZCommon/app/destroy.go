@@ -0,0 +1,123 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"azcommon/utils"
+)
+
+// destroyCommand tears down either a single VM with its NIC, OS disk and
+// public IP, keeping the shared vnet and NSG, or the whole resource group
+func destroyCommand(profile utils.Profile) *command {
+	return &command{
+		name:    "destroy",
+		summary: "Delete a single VM or the whole resource group",
+		usage:   "(--vm NAME | --all) [--yes]",
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			vmName := fs.String("vm", "", "Delete only this VM with its NIC, OS disk and public IP, keeping the vnet and NSG")
+			all := fs.Bool("all", false, "Delete the whole resource group and everything in it")
+			yes := fs.Bool("yes", false, "Do not ask for confirmation")
+			var cf configFlags
+			cf.register(fs)
+			return func(args []string) {
+				if (*vmName == "") == !*all {
+					fs.Usage()
+					utils.LogAndExit(fmt.Errorf("exactly one of --vm or --all is required"), "Invalid arguments")
+				}
+				runDestroy(profile, cf.load(), *vmName, *yes)
+			}
+		},
+	}
+}
+
+// runDestroy deletes vmName with its resources, or the whole resource group
+// when vmName is empty
+func runDestroy(profile utils.Profile, cfg *utils.Config, vmName string, yes bool) {
+	ctx := context.Background()
+	cred, err := utils.NewCredential(cfg)
+	utils.LogAndExit(err, "Failed to get credentials")
+
+	state, err := utils.LoadState(cfg.ResourceGroupName)
+	utils.LogAndExit(err, "Failed to load deployment state")
+
+	utils.InfoLogger.Printf("Starting %s teardown in resource group %s", profile.Name, cfg.ResourceGroupName)
+
+	if vmName == "" {
+		if !yes && !confirm(fmt.Sprintf("Delete resource group %q and everything in it?", cfg.ResourceGroupName)) {
+			fmt.Println("Aborted, nothing was deleted")
+			return
+		}
+		utils.LogAndExit(utils.DeleteResourceGroup(ctx, cred, cfg), "Failed to delete resource group")
+		utils.LogAndExit(state.Remove(), "Failed to clear deployment state")
+		printDestroySummary([]utils.ResourceRef{{Type: utils.TypeResourceGroup, Name: cfg.ResourceGroupName}}, nil)
+		return
+	}
+
+	refs, err := utils.VMResources(ctx, cred, cfg, vmName)
+	utils.LogAndExit(err, "Failed to look up VM resources")
+
+	fmt.Printf("The following resources will be deleted from %s:\n", cfg.ResourceGroupName)
+	for _, ref := range refs {
+		fmt.Printf("  - %s\n", ref)
+	}
+	if !yes && !confirm("Continue?") {
+		fmt.Println("Aborted, nothing was deleted")
+		return
+	}
+
+	var removed, failed []utils.ResourceRef
+	for _, ref := range refs {
+		if err := utils.DeleteResource(ctx, cred, cfg, ref); err != nil {
+			failed = append(failed, ref)
+			continue
+		}
+		removed = append(removed, ref)
+	}
+
+	// The configured VM is gone, a later --resume has to recreate it
+	if vmName == cfg.VMName {
+		for _, step := range []string{stepVM, stepNIC, stepPublicIP, stepPublicIPv6} {
+			delete(state.Steps, step)
+		}
+		// Without any step left the file would only keep listing the
+		// deleted ones, so it goes away
+		if state.Exists() {
+			utils.LogAndExit(state.Save(), "Failed to save deployment state")
+		} else {
+			utils.LogAndExit(state.Remove(), "Failed to clear deployment state")
+		}
+	}
+
+	printDestroySummary(removed, failed)
+	if len(failed) > 0 {
+		os.Exit(1)
+	}
+}
+
+// printDestroySummary lists what was removed and what could not be removed
+func printDestroySummary(removed, failed []utils.ResourceRef) {
+	fmt.Println("\nTeardown summary")
+	fmt.Println("============================================")
+	for _, ref := range removed {
+		fmt.Printf("removed: %s\n", ref)
+	}
+	for _, ref := range failed {
+		fmt.Printf("FAILED:  %s (see log for details)\n", ref)
+	}
+}
+
+// confirm asks a yes/no question on stdin, anything but y or yes is a no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N]: ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
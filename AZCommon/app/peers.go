@@ -0,0 +1,235 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"azcommon/utils"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// peersCommand groups the commands that manage the VPN clients
+func peersCommand(profile utils.Profile) *command {
+	return &command{
+		name:        "peers",
+		summary:     fmt.Sprintf("Manage the %s clients of the server", profile.Name),
+		subcommands: []*command{peersAddCommand(profile), peersRemoveCommand(profile), peersListCommand(profile), peersQRCommand(profile), peersSyncCommand(profile)},
+	}
+}
+
+// peerManager returns the profile's PeerManager or exits when the flavor
+// has none
+func peerManager(profile utils.Profile) utils.PeerManager {
+	if profile.Peers == nil {
+		utils.LogAndExit(fmt.Errorf("%s does not manage clients", profile.Name), "Peers are not supported")
+	}
+	return profile.Peers
+}
+
+// peerName returns the single positional argument of a peers command
+func peerName(args []string) string {
+	if len(args) != 1 {
+		utils.LogAndExit(fmt.Errorf("expected one peer name after the flags, got %d arguments", len(args)), "Invalid arguments")
+	}
+	return args[0]
+}
+
+// peersAddCommand creates a client and pushes it to the server
+func peersAddCommand(profile utils.Profile) *command {
+	return &command{
+		name:    "add",
+		summary: "Add a client, write its config and update the server",
+		usage:   "[--endpoint HOST[:PORT]] [--dns IP]... [--split CIDR]... [--preshared-key] [--qr] [--no-sync] <name>",
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			var dns, split stringList
+			endpoint := fs.String("endpoint", "", "Address clients connect to (default the public IP and VPN port)")
+			fs.Var(&dns, "dns", "DNS server used by the client (repeatable, default CLIENT_DNS or "+utils.DefaultClientDNS+")")
+			fs.Var(&split, "split", "Only route this CIDR through the VPN (repeatable, default all traffic)")
+			psk := fs.Bool("preshared-key", false, "Add a preshared key for an extra layer of symmetric encryption")
+			qr := fs.Bool("qr", false, "Also show the config as a QR code and write it as a PNG next to it")
+			noSync := fs.Bool("no-sync", false, "Only record the client locally, run \"peers sync\" later")
+			var cf configFlags
+			cf.register(fs)
+			return func(args []string) {
+				name := peerName(args)
+				utils.LogAndExit(utils.ValidatePeerName(name), "Invalid arguments")
+				cfg := cf.load()
+				peers := peerManager(profile)
+				ctx := context.Background()
+
+				var cred azcore.TokenCredential
+				if *endpoint == "" || !*noSync {
+					var err error
+					cred, err = utils.NewCredential(cfg)
+					utils.LogAndExit(err, "Failed to get credentials")
+				}
+				port := strconv.Itoa(cfg.VPNPort(profile))
+				if *endpoint == "" {
+					publicIP, err := utils.GetPublicIPAddress(ctx, cred, cfg)
+					utils.LogAndExit(err, "Failed to look up the public IP address, use --endpoint")
+					*endpoint = net.JoinHostPort(publicIP, port)
+				} else if _, _, err := net.SplitHostPort(*endpoint); err != nil {
+					*endpoint = net.JoinHostPort(*endpoint, port)
+				}
+
+				client, err := peers.Add(cfg, profile, name, utils.PeerOptions{
+					Endpoint:     *endpoint,
+					DNS:          dns,
+					SplitTunnel:  split,
+					PresharedKey: *psk,
+				})
+				utils.LogAndExit(err, "Failed to add peer")
+				fmt.Printf("Client config for %s written to %s\n\n", client.Name, client.Path)
+				os.Stdout.Write(client.Content)
+				fmt.Println()
+				if *qr {
+					if err := printQRCode(client, true); err != nil {
+						fmt.Printf("Warning: %v\n\n", err)
+					}
+				}
+				syncPeers(ctx, cred, cfg, profile, *noSync)
+			}
+		},
+	}
+}
+
+// peersRemoveCommand forgets a client and drops it from the server
+func peersRemoveCommand(profile utils.Profile) *command {
+	return &command{
+		name:    "remove",
+		summary: "Remove a client and update the server",
+		usage:   "[--no-sync] <name>",
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			noSync := fs.Bool("no-sync", false, "Only forget the client locally, run \"peers sync\" later")
+			var cf configFlags
+			cf.register(fs)
+			return func(args []string) {
+				name := peerName(args)
+				cfg := cf.load()
+				utils.LogAndExit(peerManager(profile).Remove(cfg, name), "Failed to remove peer")
+				fmt.Printf("Removed peer %s\n", name)
+				if !*noSync {
+					cred, err := utils.NewCredential(cfg)
+					utils.LogAndExit(err, "Failed to get credentials")
+					syncPeers(context.Background(), cred, cfg, profile, false)
+				}
+			}
+		},
+	}
+}
+
+// peersListCommand prints the recorded clients
+func peersListCommand(profile utils.Profile) *command {
+	return &command{
+		name:    "list",
+		summary: "List the clients recorded for this deployment",
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			var cf configFlags
+			cf.register(fs)
+			return func(args []string) {
+				cfg := cf.load()
+				peers, err := peerManager(profile).List(cfg)
+				utils.LogAndExit(err, "Failed to list peers")
+				if len(peers) == 0 {
+					fmt.Printf("No peers recorded for %s, add one with: %s peers add <name>\n", cfg.ResourceGroupName, programName())
+					return
+				}
+
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "NAME\tADDRESS\tTUNNEL\tPSK\tCREATED\tKEY")
+				for _, peer := range peers {
+					address, tunnel, psk := peer.Address, "full", "no"
+					if address == "" {
+						address = "dynamic"
+					}
+					if peer.SplitTunnel {
+						tunnel = "split"
+					}
+					if peer.PresharedKey {
+						psk = "yes"
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", peer.Name, address, tunnel, psk, peer.CreatedAt.Format("2006-01-02"), peer.PublicKey)
+				}
+				w.Flush()
+			}
+		},
+	}
+}
+
+// peersQRCommand shows the config of a recorded client as a QR code
+func peersQRCommand(profile utils.Profile) *command {
+	return &command{
+		name:    "qr",
+		summary: "Show a client's config as a QR code for mobile apps and write it as a PNG",
+		usage:   "[--no-png] <name>",
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			noPNG := fs.Bool("no-png", false, "Only show the QR code in the terminal")
+			var cf configFlags
+			cf.register(fs)
+			return func(args []string) {
+				name := peerName(args)
+				cfg := cf.load()
+				client, err := peerManager(profile).Client(cfg, name)
+				utils.LogAndExit(err, "Failed to load peer")
+				utils.LogAndExit(printQRCode(client, !*noPNG), "Failed to render the QR code")
+			}
+		},
+	}
+}
+
+// printQRCode shows the client's config as a QR code and optionally writes
+// the PNG. It fails before printing anything when the profile is too large
+// for one QR code.
+func printQRCode(client *utils.ClientProfile, png bool) error {
+	code, err := client.QRCodeTerminal()
+	if err != nil {
+		return err
+	}
+	fmt.Print(code)
+	fmt.Printf("\nScan the code above to import %s\n", client.Name)
+	if png {
+		path, err := client.WriteQRCodePNG()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("QR code written to %s\n", path)
+	}
+	fmt.Println()
+	return nil
+}
+
+// peersSyncCommand pushes the recorded clients to the server
+func peersSyncCommand(profile utils.Profile) *command {
+	return &command{
+		name:    "sync",
+		summary: "Make the server's client list match the recorded clients",
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			var cf configFlags
+			cf.register(fs)
+			return func(args []string) {
+				cfg := cf.load()
+				cred, err := utils.NewCredential(cfg)
+				utils.LogAndExit(err, "Failed to get credentials")
+				syncPeers(context.Background(), cred, cfg, profile, false)
+			}
+		},
+	}
+}
+
+// syncPeers updates the server unless skip is set. A failure leaves the
+// local records as they are, so the sync can be retried on its own.
+func syncPeers(ctx context.Context, cred azcore.TokenCredential, cfg *utils.Config, profile utils.Profile, skip bool) {
+	if skip {
+		fmt.Printf("Server not updated, run \"%s peers sync\" to apply the change\n", programName())
+		return
+	}
+	err := peerManager(profile).Sync(ctx, cred, cfg, profile)
+	utils.LogAndExit(err, fmt.Sprintf("Failed to update the server, the change is recorded locally, retry with \"%s peers sync\"", programName()))
+	fmt.Printf("Server %s updated\n", cfg.VMName)
+}
@@ -0,0 +1,122 @@
+package app
+
+import (
+	"azcommon/utils"
+)
+
+// createdResource is a resource made by the current run
+type createdResource struct {
+	step string
+	ref  utils.ResourceRef
+}
+
+// stepResources lists the resources a deployment step creates
+func (d *deployment) stepResources(step string) []utils.ResourceRef {
+	cfg := d.cfg
+	switch step {
+	case stepVnet:
+		return []utils.ResourceRef{{Type: utils.TypeVirtualNetwork, Name: cfg.VnetName}}
+	case stepSubnet:
+		return []utils.ResourceRef{{Type: utils.TypeSubnet, Name: cfg.SubnetName, Parent: cfg.VnetName}}
+	case stepPublicIP:
+		return []utils.ResourceRef{{Type: utils.TypePublicIP, Name: cfg.PublicIPName}}
+	case stepPublicIPv6:
+		return []utils.ResourceRef{{Type: utils.TypePublicIP, Name: cfg.PublicIPv6Name()}}
+	case stepNsg:
+		return []utils.ResourceRef{{Type: utils.TypeNSG, Name: cfg.NSGName}}
+	case stepNIC:
+		return []utils.ResourceRef{{Type: utils.TypeNIC, Name: cfg.NICName}}
+	case stepVM:
+		return []utils.ResourceRef{{Type: utils.TypeVM, Name: cfg.VMName}}
+	case stepBudget:
+		// A budget costs nothing and goes away with the resource group
+		return nil
+	}
+	return nil
+}
+
+// track remembers the resources of a step that is about to run, unless an
+// earlier run already completed it. Resources are tracked before they are
+// created so a half finished create is rolled back as well.
+func (d *deployment) track(step string) {
+	if _, recorded := d.state.Steps[step]; recorded {
+		return
+	}
+	if step == stepResourceGroup {
+		d.createdGroup = !d.groupExisted
+		return
+	}
+	for _, ref := range d.stepResources(step) {
+		d.created = append(d.created, createdResource{step: step, ref: ref})
+	}
+}
+
+// rollback deletes every resource created by this run in reverse dependency
+// order. The resource group is only deleted if this run created it.
+func (d *deployment) rollback() {
+	utils.InfoLogger.Printf("Rolling back %d resources created by this run", len(d.created))
+
+	// Child resources go away with their parent, no need to delete them one
+	// by one
+	parents := map[string]utils.ResourceRef{}
+	for _, res := range d.created {
+		if res.ref.Parent == "" {
+			parents[res.ref.Name] = res.ref
+		}
+	}
+
+	// Each resource is deleted once, the error is kept for the parent's own
+	// turn
+	results := map[utils.ResourceRef]error{}
+	remove := func(ref utils.ResourceRef) error {
+		if err, done := results[ref]; done {
+			return err
+		}
+		err := utils.DeleteResource(d.ctx, d.cred, d.cfg, ref)
+		if err != nil {
+			utils.ErrorLogger.Printf("Rollback could not delete %s, remove it manually: %v", ref, err)
+		}
+		results[ref] = err
+		return err
+	}
+
+	for i := len(d.created) - 1; i >= 0; i-- {
+		res := d.created[i]
+		ref := res.ref
+		if parent, ok := parents[ref.Parent]; ok && ref.Parent != "" {
+			// The parent goes in the child's place rather than its own. A
+			// subnet can reference the NSG created before it, which Azure
+			// refuses to delete while the subnet still exists.
+			utils.InfoLogger.Printf("Deleting %s now, %s is removed with it", parent, ref)
+			ref = parent
+		}
+		if remove(ref) == nil {
+			delete(d.state.Steps, res.step)
+		}
+	}
+
+	failed := 0
+	for _, err := range results {
+		if err != nil {
+			failed++
+		}
+	}
+
+	if d.createdGroup && failed == 0 {
+		if err := utils.DeleteResourceGroup(d.ctx, d.cred, d.cfg); err != nil {
+			utils.ErrorLogger.Printf("Rollback could not delete resource group %s: %v", d.cfg.ResourceGroupName, err)
+			failed++
+		} else {
+			delete(d.state.Steps, stepResourceGroup)
+		}
+	}
+
+	if err := d.state.Save(); err != nil {
+		utils.ErrorLogger.Printf("Failed to save deployment state: %v", err)
+	}
+	if failed > 0 {
+		utils.ErrorLogger.Printf("Rollback finished with %d resources left behind", failed)
+		return
+	}
+	utils.InfoLogger.Println("Rollback completed, no resources from this run are left behind")
+}
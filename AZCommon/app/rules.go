@@ -0,0 +1,162 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"azcommon/utils"
+)
+
+// rulesListCommand prints the rules currently in the NSG
+func rulesListCommand() *command {
+	return &command{
+		name:    "list",
+		summary: "List the security rules currently in the NSG",
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			var cf configFlags
+			cf.register(fs)
+			return func(args []string) {
+				cfg := cf.load()
+				cred, err := utils.NewCredential(cfg)
+				utils.LogAndExit(err, "Failed to get credentials")
+
+				rules, err := utils.ListNetSecRules(context.Background(), cred, cfg)
+				utils.LogAndExit(err, "Failed to list security rules")
+
+				fmt.Printf("Security rules in %s:\n", cfg.NSGName)
+				for _, rule := range rules {
+					fmt.Printf("  %-24s %s\n", *rule.Name, utils.DescribeRule(*rule))
+				}
+			}
+		},
+	}
+}
+
+// rulesLockdownCommand restricts the management ports of the live rules to
+// the given sources, leaving the VPN port public
+func rulesLockdownCommand(profile utils.Profile) *command {
+	return &command{
+		name:    "lockdown",
+		summary: "Restrict SSH and other management ports to your IP or given CIDRs",
+		usage:   "[--source CIDR]... [--my-ip] [--ports PORTS] [--dry-run]",
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			var sources stringList
+			fs.Var(&sources, "source", "CIDR allowed to reach the management ports (repeatable, default MANAGEMENT_SOURCES)")
+			myIP := fs.Bool("my-ip", false, "Allow your current public IP, as reported by IP_RESOLVER_URL")
+			ports := fs.String("ports", "", "Management ports to restrict, e.g. 22,3389 (default MANAGEMENT_PORTS or "+utils.DefaultManagementPorts+")")
+			dryRun := fs.Bool("dry-run", false, "Only print the rules that would change")
+			var cf configFlags
+			cf.register(fs)
+			return func(args []string) {
+				cfg := cf.load()
+				ctx := context.Background()
+
+				if len(sources) > 0 || *myIP {
+					wanted := []string(sources)
+					if *myIP {
+						wanted = append(wanted, utils.MyIPSource)
+					}
+					cfg.ManagementSources = strings.Join(wanted, ",")
+				}
+				if *ports != "" {
+					cfg.ManagementPorts = *ports
+				}
+				if cfg.ManagementSources == "" {
+					utils.LogAndExit(fmt.Errorf("no sources given"), "Use --source or --my-ip, or set MANAGEMENT_SOURCES")
+				}
+				utils.LogAndExit(cfg.Validate(), "Invalid lockdown settings")
+				utils.LogAndExit(cfg.ResolveManagementSources(ctx, cfg.IPResolver()), "Failed to resolve sources")
+
+				cred, err := utils.NewCredential(cfg)
+				utils.LogAndExit(err, "Failed to get credentials")
+
+				changed, err := utils.LockDownNetSecRules(ctx, cred, cfg, profile, *dryRun)
+				utils.LogAndExit(err, "Failed to lock down security rules")
+
+				verb := "Restricted"
+				if *dryRun {
+					verb = "Would restrict"
+				}
+				if len(changed) == 0 {
+					fmt.Println("No management rules to change.")
+					return
+				}
+				for _, rule := range changed {
+					fmt.Printf("%s %-24s %s\n", verb, *rule.Name, utils.DescribeRule(rule))
+				}
+				if !*dryRun {
+					fmt.Printf("Set MANAGEMENT_SOURCES=%s to keep this on the next deploy.\n", cfg.ManagementSources)
+				}
+			}
+		},
+	}
+}
+
+// rulesDiffCommand compares the declared rules with the live NSG
+func rulesDiffCommand(profile utils.Profile) *command {
+	return &command{
+		name:    "diff",
+		summary: "Show how the rules in the NSG differ from the declared rules",
+		usage:   "[--exit-code]",
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			exitCode := fs.Bool("exit-code", false, "Exit with status 1 when sync has changes to make")
+			var cf configFlags
+			cf.register(fs)
+			return func(args []string) {
+				cfg := cf.load()
+				changes := diffRules(cfg, profile)
+				utils.WriteRuleDiff(os.Stdout, changes)
+				if *exitCode && utils.PendingRuleChanges(changes) {
+					utils.CloseLogger()
+					os.Exit(1)
+				}
+			}
+		},
+	}
+}
+
+// rulesSyncCommand makes the NSG match the declared rules
+func rulesSyncCommand(profile utils.Profile) *command {
+	return &command{
+		name:    "sync",
+		summary: "Add, update and remove NSG rules to match the declared rules",
+		usage:   "[--yes]",
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			yes := fs.Bool("yes", false, "Apply the changes without asking for confirmation")
+			var cf configFlags
+			cf.register(fs)
+			return func(args []string) {
+				cfg := cf.load()
+				changes := diffRules(cfg, profile)
+				utils.WriteRuleDiff(os.Stdout, changes)
+				if !utils.PendingRuleChanges(changes) {
+					return
+				}
+				if !*yes && !confirm(fmt.Sprintf("Apply these changes to %s?", cfg.NSGName)) {
+					fmt.Println("Aborted, nothing was changed.")
+					return
+				}
+
+				cred, err := utils.NewCredential(cfg)
+				utils.LogAndExit(err, "Failed to get credentials")
+				utils.LogAndExit(utils.SyncNetSecRules(context.Background(), cred, cfg, changes), "Failed to sync security rules")
+				fmt.Printf("The rules in %s match the declared rules.\n", cfg.NSGName)
+			}
+		},
+	}
+}
+
+// diffRules resolves the management sources and diffs the declared rules
+// against the live NSG
+func diffRules(cfg *utils.Config, profile utils.Profile) []utils.RuleChange {
+	ctx := context.Background()
+	utils.LogAndExit(cfg.ResolveManagementSources(ctx, cfg.IPResolver()), "Failed to resolve MANAGEMENT_SOURCES")
+	cred, err := utils.NewCredential(cfg)
+	utils.LogAndExit(err, "Failed to get credentials")
+	changes, err := utils.DiffNetSecRules(ctx, cred, cfg, profile)
+	utils.LogAndExit(err, "Failed to compare security rules")
+	return changes
+}
@@ -0,0 +1,68 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"azcommon/utils"
+)
+
+// sshCommand opens an SSH session to the VM's public IP
+func sshCommand(profile utils.Profile) *command {
+	return &command{
+		name:    "ssh",
+		summary: fmt.Sprintf("Open an SSH session to the %s VM", profile.Name),
+		usage:   "[--identity FILE] [--print] [-- ssh arguments...]",
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			identity := fs.String("identity", "", "Private key to use, defaults to SSH_PUB_KEY_PATH without .pub when it exists")
+			printOnly := fs.Bool("print", false, "Print the ssh command instead of running it")
+			var cf configFlags
+			cf.register(fs)
+			return func(args []string) {
+				cfg := cf.load()
+				cred, err := utils.NewCredential(cfg)
+				utils.LogAndExit(err, "Failed to get credentials")
+
+				publicIP, err := utils.GetPublicIPAddress(context.Background(), cred, cfg)
+				utils.LogAndExit(err, "Failed to look up the public IP address")
+
+				sshArgs := sshArguments(cfg, *identity, publicIP, args)
+				if *printOnly {
+					fmt.Println("ssh " + strings.Join(sshArgs, " "))
+					return
+				}
+
+				utils.InfoLogger.Printf("Running ssh %s", strings.Join(sshArgs, " "))
+				cmd := exec.Command("ssh", sshArgs...)
+				cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+				if err := cmd.Run(); err != nil {
+					if exitErr, ok := err.(*exec.ExitError); ok {
+						os.Exit(exitErr.ExitCode())
+					}
+					utils.LogAndExit(err, "Failed to run ssh")
+				}
+			}
+		},
+	}
+}
+
+// sshArguments builds the ssh command line for the admin user at publicIP
+func sshArguments(cfg *utils.Config, identity, publicIP string, extra []string) []string {
+	if identity == "" && strings.HasSuffix(cfg.SSHPubKeyPath, ".pub") {
+		privateKey := strings.TrimSuffix(cfg.SSHPubKeyPath, ".pub")
+		if _, err := os.Stat(privateKey); err == nil {
+			identity = privateKey
+		}
+	}
+
+	var args []string
+	if identity != "" {
+		args = append(args, "-i", identity)
+	}
+	args = append(args, fmt.Sprintf("%s@%s", cfg.AdminUsername, publicIP))
+	return append(args, extra...)
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"azcommon/utils"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+// statusCommand reports what exists in Azure and what the state file records
+func statusCommand(profile utils.Profile) *command {
+	return &command{
+		name:    "status",
+		summary: "Show the deployment state, VM power state and public IP",
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			var cf configFlags
+			cf.register(fs)
+			return func(args []string) {
+				runStatus(profile, cf.load())
+			}
+		},
+	}
+}
+
+func runStatus(profile utils.Profile, cfg *utils.Config) {
+	ctx := context.Background()
+	cred, err := utils.NewCredential(cfg)
+	utils.LogAndExit(err, "Failed to get credentials")
+
+	state, err := utils.LoadState(cfg.ResourceGroupName)
+	utils.LogAndExit(err, "Failed to load deployment state")
+
+	groupsClient, err := armresources.NewResourceGroupsClient(cfg.SubscriptionID, cred, nil)
+	utils.LogAndExit(err, "Failed to create resource groups client")
+	exists, err := groupsClient.CheckExistence(ctx, cfg.ResourceGroupName, nil)
+	utils.LogAndExit(err, "Failed to check the resource group")
+
+	fmt.Printf("%s deployment status\n", profile.Name)
+	fmt.Println("============================================")
+	if exists.Success {
+		fmt.Printf("Resource group: %s (exists)\n", cfg.ResourceGroupName)
+	} else {
+		fmt.Printf("Resource group: %s (does not exist)\n", cfg.ResourceGroupName)
+	}
+
+	if !state.Exists() {
+		fmt.Printf("Deployment state: none recorded in %s\n", utils.StatePath(cfg.ResourceGroupName))
+	} else {
+		fmt.Printf("Deployment state: %s, updated %s\n", utils.StatePath(cfg.ResourceGroupName), state.UpdatedAt.Local().Format("2006-01-02 15:04:05"))
+		for _, step := range steps {
+			if (step == stepBudget && cfg.BudgetAmount == "") || (step == stepPublicIPv6 && !cfg.DualStack()) {
+				continue
+			}
+			if done, ok := state.Steps[step]; ok {
+				fmt.Printf("  %-14s done     %s\n", step, done.ResourceID)
+			} else if step == state.FailedStep {
+				fmt.Printf("  %-14s FAILED   %s\n", step, state.LastError)
+			} else {
+				fmt.Printf("  %-14s pending\n", step)
+			}
+		}
+	}
+
+	if !exists.Success {
+		return
+	}
+
+	powerState, err := utils.GetVMPowerState(ctx, cred, cfg)
+	utils.LogAndExit(err, "Failed to get the VM power state")
+	fmt.Printf("VM %s: %s\n", cfg.VMName, powerState)
+
+	publicIP, err := utils.GetPublicIPAddress(ctx, cred, cfg)
+	if err != nil {
+		utils.ErrorLogger.Printf("Failed to look up the public IP address: %v", err)
+		fmt.Printf("Public IP %s: unavailable\n", cfg.PublicIPName)
+		return
+	}
+	fmt.Printf("Public IP %s: %s\n", cfg.PublicIPName, publicIP)
+	if cfg.DualStack() {
+		publicIPv6, err := utils.GetPublicIPv6Address(ctx, cred, cfg)
+		if err != nil {
+			utils.ErrorLogger.Printf("Failed to look up the IPv6 public IP address: %v", err)
+			publicIPv6 = "unavailable"
+		}
+		fmt.Printf("Public IP %s: %s\n", cfg.PublicIPv6Name(), publicIPv6)
+	}
+}
+
+// listCommand lists the resource groups of the subscription
+func listCommand() *command {
+	return &command{
+		name:    "list",
+		summary: "List the resource groups in the subscription",
+		setup: func(fs *flag.FlagSet) func(args []string) {
+			var cf configFlags
+			cf.register(fs)
+			return func(args []string) {
+				cfg := cf.loadSubscription()
+				cred, err := utils.NewCredential(cfg)
+				utils.LogAndExit(err, "Failed to get credentials")
+				utils.GetRg(cred, cfg)
+			}
+		},
+	}
+}
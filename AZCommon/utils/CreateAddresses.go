@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+// SubnetParams builds the subnet request body sent by CreateAddresses. A
+// dual stack subnet lists both prefixes. The NSG is associated with the
+// subnet when NSG_ATTACH asks for it.
+func SubnetParams(cfg *Config, nsgID string) armnetwork.Subnet {
+	subnet := armnetwork.Subnet{
+		Properties: &armnetwork.SubnetPropertiesFormat{
+			AddressPrefix: to.Ptr(cfg.SubnetPrefix),
+		},
+	}
+	if cfg.DualStack() {
+		subnet.Properties.AddressPrefix = nil
+		subnet.Properties.AddressPrefixes = []*string{to.Ptr(cfg.SubnetPrefix), to.Ptr(cfg.SubnetPrefixV6)}
+	}
+	if cfg.AttachNSGToSubnet() {
+		subnet.Properties.NetworkSecurityGroup = &armnetwork.SecurityGroup{ID: to.Ptr(nsgID)}
+	}
+	return subnet
+}
+
+// PublicIPParams builds the static Standard public IP request body sent by CreateAddresses
+func PublicIPParams(cfg *Config) armnetwork.PublicIPAddress {
+	return armnetwork.PublicIPAddress{
+		Location: to.Ptr(cfg.Location),
+		Properties: &armnetwork.PublicIPAddressPropertiesFormat{
+			PublicIPAllocationMethod: to.Ptr(armnetwork.IPAllocationMethodStatic),
+		},
+		SKU: &armnetwork.PublicIPAddressSKU{
+			Name: to.Ptr(armnetwork.PublicIPAddressSKUNameStandard),
+		},
+	}
+}
+
+// CreateAddresses creates both the subnet and public IP address
+// Returns pollers for both operations to allow the caller to control polling frequency
+func CreateAddresses(
+	ctx context.Context,
+	cred azcore.TokenCredential,
+	cfg *Config,
+	nsgID string,
+) (
+	*runtime.Poller[armnetwork.SubnetsClientCreateOrUpdateResponse],
+	*runtime.Poller[armnetwork.PublicIPAddressesClientCreateOrUpdateResponse],
+	error,
+) {
+	// Create Subnet
+	subnetName := cfg.SubnetName
+	subnetPrefix := cfg.SubnetPrefix
+	InfoLogger.Printf("Creating subnet %s with prefix %s", subnetName, subnetPrefix)
+
+	subnetClient, err := armnetwork.NewSubnetsClient(cfg.SubscriptionID, cred, nil)
+	if err != nil {
+		ErrorLogger.Printf("Failed to create subnet client: %v", err)
+		return nil, nil, fmt.Errorf("failed to create subnet client: %v", err)
+	}
+
+	InfoLogger.Printf("Initiating subnet creation in VNet %s...", cfg.VnetName)
+	subnetPoller, err := subnetClient.BeginCreateOrUpdate(ctx, cfg.ResourceGroupName, cfg.VnetName, subnetName, SubnetParams(cfg, nsgID), nil)
+	if err != nil {
+		ErrorLogger.Printf("Failed to begin subnet creation: %v", err)
+		return nil, nil, fmt.Errorf("failed to begin subnet creation: %v", err)
+	}
+
+	// Create Public IP Address
+	publicIPName := cfg.PublicIPName
+	InfoLogger.Printf("Creating public IP address %s in %s", publicIPName, cfg.Location)
+
+	publicIPClient, err := armnetwork.NewPublicIPAddressesClient(cfg.SubscriptionID, cred, nil)
+	if err != nil {
+		ErrorLogger.Printf("Failed to create public IP client: %v", err)
+		return nil, nil, fmt.Errorf("failed to create public IP client: %v", err)
+	}
+
+	InfoLogger.Printf("Initiating public IP creation with static allocation...")
+	publicIPPoller, err := publicIPClient.BeginCreateOrUpdate(ctx, cfg.ResourceGroupName, publicIPName, PublicIPParams(cfg), nil)
+	if err != nil {
+		ErrorLogger.Printf("Failed to begin public IP creation: %v", err)
+		return nil, nil, fmt.Errorf("failed to begin public IP creation: %v", err)
+	}
+
+	InfoLogger.Printf("Network address creation initiated successfully")
+	return subnetPoller, publicIPPoller, nil
+}
+
+// GetPublicIPAddress returns the IP address assigned to the configured public IP
+func GetPublicIPAddress(ctx context.Context, cred azcore.TokenCredential, cfg *Config) (string, error) {
+	return publicIPAddress(ctx, cred, cfg, cfg.PublicIPName)
+}
+
+func publicIPAddress(ctx context.Context, cred azcore.TokenCredential, cfg *Config, name string) (string, error) {
+	publicIPClient, err := armnetwork.NewPublicIPAddressesClient(cfg.SubscriptionID, cred, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create public IP client: %v", err)
+	}
+	publicIP, err := publicIPClient.Get(ctx, cfg.ResourceGroupName, name, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get public IP %s: %v", name, err)
+	}
+	if publicIP.Properties == nil || publicIP.Properties.IPAddress == nil {
+		return "", fmt.Errorf("public IP %s has no address assigned", name)
+	}
+	return *publicIP.Properties.IPAddress, nil
+}
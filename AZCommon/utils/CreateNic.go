@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+// NICParams builds the network interface request body sent by CreateNIC.
+// For dual stack publicIPv6ID is the IPv6 public IP, which gets an IP
+// configuration of its own next to the primary IPv4 one; it is ignored
+// otherwise.
+func NICParams(cfg *Config, subnetID, publicIPID, publicIPv6ID, nsgID string) armnetwork.Interface {
+	nic := armnetwork.Interface{
+		Location: to.Ptr(cfg.Location),
+		Properties: &armnetwork.InterfacePropertiesFormat{
+			IPConfigurations: []*armnetwork.InterfaceIPConfiguration{
+				{
+					Name: to.Ptr(cfg.NICName),
+					Properties: &armnetwork.InterfaceIPConfigurationPropertiesFormat{
+						Subnet: &armnetwork.Subnet{
+							ID: to.Ptr(subnetID),
+						},
+						PublicIPAddress: &armnetwork.PublicIPAddress{
+							ID: to.Ptr(publicIPID),
+						},
+					},
+				},
+			},
+		},
+	}
+	if cfg.DualStack() {
+		nic.Properties.IPConfigurations[0].Properties.Primary = to.Ptr(true)
+		nic.Properties.IPConfigurations = append(nic.Properties.IPConfigurations, &armnetwork.InterfaceIPConfiguration{
+			Name: to.Ptr(cfg.NICName + "-v6"),
+			Properties: &armnetwork.InterfaceIPConfigurationPropertiesFormat{
+				PrivateIPAddressVersion: to.Ptr(armnetwork.IPVersionIPv6),
+				Subnet: &armnetwork.Subnet{
+					ID: to.Ptr(subnetID),
+				},
+				PublicIPAddress: &armnetwork.PublicIPAddress{
+					ID: to.Ptr(publicIPv6ID),
+				},
+			},
+		})
+	}
+	if cfg.AttachNSGToNIC() {
+		nic.Properties.NetworkSecurityGroup = &armnetwork.SecurityGroup{
+			ID: to.Ptr(nsgID),
+		}
+	}
+	return nic
+}
+
+func CreateNIC(
+	ctx context.Context,
+	cred azcore.TokenCredential,
+	cfg *Config,
+	subnetID *string,
+	publicIPID *string,
+	publicIPv6ID *string,
+	nsgID *string,
+) (
+	nicResult *runtime.Poller[armnetwork.InterfacesClientCreateOrUpdateResponse],
+	err error) {
+
+	nicName := cfg.NICName
+	InfoLogger.Printf("Creating network interface %s in %s", nicName, cfg.Location)
+	InfoLogger.Printf("Using subnet ID: %s", *subnetID)
+	InfoLogger.Printf("Using public IP ID: %s", *publicIPID)
+	if cfg.DualStack() {
+		InfoLogger.Printf("Using IPv6 public IP ID: %s", *publicIPv6ID)
+	}
+	InfoLogger.Printf("Using NSG ID: %s", *nsgID)
+
+	nicParams := NICParams(cfg, *subnetID, *publicIPID, *publicIPv6ID, *nsgID)
+
+	// Create or update NIC
+	nicClient, err := armnetwork.NewInterfacesClient(cfg.SubscriptionID, cred, nil)
+	if err != nil {
+		ErrorLogger.Printf("Failed to create network interface client: %v", err)
+		return nil, fmt.Errorf("failed to create network interfaces client: %v", err)
+	}
+
+	InfoLogger.Printf("Beginning network interface creation...")
+	nicPoller, err := nicClient.BeginCreateOrUpdate(ctx, cfg.ResourceGroupName, nicName, nicParams, nil)
+	if err != nil {
+		ErrorLogger.Printf("Failed to begin NIC creation: %v", err)
+		return nil, fmt.Errorf("failed to begin NIC creation: %v", err)
+	}
+
+	InfoLogger.Printf("Network interface creation initiated successfully")
+	return nicPoller, nil
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+// Values of NSG_ATTACH, where the NSG is associated
+const (
+	NSGAttachNIC    = "nic"
+	NSGAttachSubnet = "subnet"
+	NSGAttachBoth   = "both"
+)
+
+// NSGAttachValues lists the accepted values of NSG_ATTACH
+func NSGAttachValues() []string {
+	return []string{NSGAttachNIC, NSGAttachSubnet, NSGAttachBoth}
+}
+
+// AttachNSGToNIC reports whether the NSG is associated with the NIC, the
+// default
+func (c *Config) AttachNSGToNIC() bool {
+	return c.NSGAttach == "" || c.NSGAttach == NSGAttachNIC || c.NSGAttach == NSGAttachBoth
+}
+
+// AttachNSGToSubnet reports whether the NSG is associated with the subnet
+func (c *Config) AttachNSGToSubnet() bool {
+	return c.NSGAttach == NSGAttachSubnet || c.NSGAttach == NSGAttachBoth
+}
+
+// NsgParams builds the network security group request body sent by CreateNsg,
+// with its rules inline so the NSG and every rule are created in one operation
+func NsgParams(cfg *Config, rules []armnetwork.SecurityRule) armnetwork.SecurityGroup {
+	return armnetwork.SecurityGroup{
+		Location: to.Ptr(cfg.Location),
+		Properties: &armnetwork.SecurityGroupPropertiesFormat{
+			SecurityRules: to.SliceOfPtrs(rules...),
+		},
+	}
+}
+
+// CreateNsg creates or updates the NSG with rules, as built by NetSecRules.
+// The request replaces the whole rule list, so rules already in the NSG that
+// are not declared, like those added in the portal, are sent along and kept;
+// "rules sync" is what removes rules.
+func CreateNsg(
+	ctx context.Context,
+	cred azcore.TokenCredential,
+	cfg *Config,
+	rules []armnetwork.SecurityRule,
+) (*runtime.Poller[armnetwork.SecurityGroupsClientCreateOrUpdateResponse], error) {
+	nsgName := cfg.NSGName
+	InfoLogger.Printf("Creating Network Security Group %s in %s with %d rules", nsgName, cfg.Location, len(rules))
+
+	nsgClient, err := armnetwork.NewSecurityGroupsClient(cfg.SubscriptionID, cred, nil)
+	if err != nil {
+		ErrorLogger.Printf("Failed to create NSG client: %v", err)
+		return nil, fmt.Errorf("failed to create NSG client: %v", err)
+	}
+
+	existing, err := nsgClient.Get(ctx, cfg.ResourceGroupName, nsgName, nil)
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("failed to get NSG %s: %v", nsgName, err)
+	}
+	if err == nil && existing.Properties != nil {
+		var kept []*armnetwork.SecurityRule
+		for _, rule := range existing.Properties.SecurityRules {
+			if rule != nil && rule.Name != nil && !declaresRule(rules, *rule.Name) {
+				InfoLogger.Printf("Keeping rule %s, which is in the NSG but not declared", *rule.Name)
+				kept = append(kept, rule)
+			}
+		}
+		if problems := RuleConflicts(rules, kept); len(problems) > 0 {
+			return nil, &ValidationError{Problems: problems}
+		}
+		for _, rule := range kept {
+			rules = append(rules, *rule)
+		}
+	}
+
+	InfoLogger.Printf("Initiating NSG creation...")
+	nsgPoller, err := nsgClient.BeginCreateOrUpdate(
+		ctx,
+		cfg.ResourceGroupName,
+		nsgName,
+		NsgParams(cfg, rules),
+		nil,
+	)
+	if err != nil {
+		ErrorLogger.Printf("Failed to begin NSG creation: %v", err)
+		return nil, fmt.Errorf("failed to begin NSG creation: %v", err)
+	}
+
+	InfoLogger.Printf("NSG creation initiated successfully")
+	return nsgPoller, nil
+}
@@ -2,45 +2,28 @@ package utils
 
 import (
 	"context"
-	"os"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
 )
 
-// CreateVM creates a new virtual machine with the specified parameters
-func CreateVM(ctx context.Context, cred *azidentity.DefaultAzureCredential, subscriptionID string, resourceGroupName string, location string, nicID string) (*runtime.Poller[armcompute.VirtualMachinesClientCreateOrUpdateResponse], error) {
-	InfoLogger.Printf("Starting VM creation in resource group %s", resourceGroupName)
-
-	vmName := os.Getenv("VM_NAME")
-	adminUsername := os.Getenv("ADMIN_USERNAME")
-	sshPublicKeyPath := os.Getenv("SSH_PUB_KEY_PATH")
-	sshPublicKeyContent := os.Getenv("SSH_PUB_KEY_CONTENT")
-
-	InfoLogger.Printf("Creating VM with name: %s, username: %s", vmName, adminUsername)
-	InfoLogger.Printf("Using SSH public key path: %s", sshPublicKeyPath)
-
-	vmClient, err := armcompute.NewVirtualMachinesClient(subscriptionID, cred, nil)
-	if err != nil {
-		ErrorLogger.Printf("Failed to create VM client: %v", err)
-		return nil, err
-	}
-
-	InfoLogger.Printf("Configuring VM parameters...")
-	vmParams := armcompute.VirtualMachine{
-		Location: to.Ptr(location),
+// VMParams builds the virtual machine request body sent by CreateVM.
+// customData is the base64 cloud-init document from CustomData, if any.
+func VMParams(cfg *Config, nicID, customData string) armcompute.VirtualMachine {
+	vm := armcompute.VirtualMachine{
+		Location: to.Ptr(cfg.Location),
 		Properties: &armcompute.VirtualMachineProperties{
 			HardwareProfile: &armcompute.HardwareProfile{
 				VMSize: to.Ptr(armcompute.VirtualMachineSizeTypesStandardB2Ms),
 			},
 			StorageProfile: &armcompute.StorageProfile{
 				ImageReference: &armcompute.ImageReference{
-					Publisher: to.Ptr(os.Getenv("PUBLISHER_NAME")),
-					Offer:     to.Ptr(os.Getenv("OFFER")),
-					SKU:       to.Ptr(os.Getenv("SKU")),
-					Version:   to.Ptr(os.Getenv("VM_VERSION")),
+					Publisher: to.Ptr(cfg.PublisherName),
+					Offer:     to.Ptr(cfg.Offer),
+					SKU:       to.Ptr(cfg.SKU),
+					Version:   to.Ptr(cfg.VMVersion),
 				},
 				OSDisk: &armcompute.OSDisk{
 					CreateOption: to.Ptr(armcompute.DiskCreateOptionTypesFromImage),
@@ -51,15 +34,15 @@ func CreateVM(ctx context.Context, cred *azidentity.DefaultAzureCredential, subs
 				},
 			},
 			OSProfile: &armcompute.OSProfile{
-				ComputerName:  to.Ptr(vmName),
-				AdminUsername: to.Ptr(adminUsername),
+				ComputerName:  to.Ptr(cfg.VMName),
+				AdminUsername: to.Ptr(cfg.AdminUsername),
 				LinuxConfiguration: &armcompute.LinuxConfiguration{
 					DisablePasswordAuthentication: to.Ptr(true),
 					SSH: &armcompute.SSHConfiguration{
 						PublicKeys: []*armcompute.SSHPublicKey{
 							{
-								Path:    to.Ptr(sshPublicKeyPath),
-								KeyData: to.Ptr(sshPublicKeyContent),
+								Path:    to.Ptr(cfg.SSHPubKeyPath),
+								KeyData: to.Ptr(cfg.SSHPubKeyContent),
 							},
 						},
 					},
@@ -77,5 +60,31 @@ func CreateVM(ctx context.Context, cred *azidentity.DefaultAzureCredential, subs
 			},
 		},
 	}
-	return vmClient.BeginCreateOrUpdate(ctx, resourceGroupName, vmName, vmParams, nil)
+	if customData != "" {
+		vm.Properties.OSProfile.CustomData = to.Ptr(customData)
+	}
+	return vm
+}
+
+// CreateVM creates a new virtual machine with the specified parameters
+func CreateVM(ctx context.Context, cred azcore.TokenCredential, cfg *Config, nicID, customData string) (*runtime.Poller[armcompute.VirtualMachinesClientCreateOrUpdateResponse], error) {
+	InfoLogger.Printf("Starting VM creation in resource group %s", cfg.ResourceGroupName)
+
+	vmName := cfg.VMName
+	InfoLogger.Printf("Creating VM with name: %s, username: %s", vmName, cfg.AdminUsername)
+	InfoLogger.Printf("Using SSH public key path: %s", cfg.SSHPubKeyPath)
+
+	vmClient, err := armcompute.NewVirtualMachinesClient(cfg.SubscriptionID, cred, nil)
+	if err != nil {
+		ErrorLogger.Printf("Failed to create VM client: %v", err)
+		return nil, err
+	}
+
+	if customData != "" {
+		InfoLogger.Printf("Passing %d bytes of cloud-init custom data", len(customData))
+	}
+
+	InfoLogger.Printf("Configuring VM parameters...")
+	vmParams := VMParams(cfg, nicID, customData)
+	return vmClient.BeginCreateOrUpdate(ctx, cfg.ResourceGroupName, vmName, vmParams, nil)
 }
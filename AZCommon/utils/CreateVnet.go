@@ -5,38 +5,50 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
 )
 
+// VnetParams builds the virtual network request body sent by CreateVnet,
+// with the IPv6 address space as well for dual stack
+func VnetParams(cfg *Config) armnetwork.VirtualNetwork {
+	prefixes := []*string{to.Ptr(cfg.AddressPrefix)}
+	if cfg.DualStack() {
+		prefixes = append(prefixes, to.Ptr(cfg.AddressPrefixV6))
+	}
+	return armnetwork.VirtualNetwork{
+		Location: to.Ptr(cfg.Location),
+		Properties: &armnetwork.VirtualNetworkPropertiesFormat{
+			AddressSpace: &armnetwork.AddressSpace{
+				AddressPrefixes: prefixes,
+			},
+		},
+	}
+}
+
 func CreateVnet(
 	ctx context.Context,
-	cred *azidentity.DefaultAzureCredential,
-	subscriptionID string,
-	resourceGroupName string,
-	location string,
-	vnetName string,
-	addressPrefix string,
+	cred azcore.TokenCredential,
+	cfg *Config,
 ) (armnetwork.VirtualNetworksClientCreateOrUpdateResponse, error) {
-	InfoLogger.Printf("Creating virtual network %s in %s", vnetName, location)
+	vnetName := cfg.VnetName
+	addressPrefix := cfg.AddressPrefix
+	InfoLogger.Printf("Creating virtual network %s in %s", vnetName, cfg.Location)
 	InfoLogger.Printf("Using address prefix: %s", addressPrefix)
+	if cfg.DualStack() {
+		InfoLogger.Printf("Using IPv6 address prefix: %s", cfg.AddressPrefixV6)
+	}
 
-	vnetClient, err := armnetwork.NewVirtualNetworksClient(subscriptionID, cred, nil)
+	vnetClient, err := armnetwork.NewVirtualNetworksClient(cfg.SubscriptionID, cred, nil)
 	if err != nil {
 		ErrorLogger.Printf("Failed to create virtual network client: %v", err)
 		return armnetwork.VirtualNetworksClientCreateOrUpdateResponse{}, err
 	}
 
 	InfoLogger.Printf("Initiating virtual network creation")
-	vnetPoller, err := vnetClient.BeginCreateOrUpdate(ctx, resourceGroupName, vnetName, armnetwork.VirtualNetwork{
-		Location: &location,
-		Properties: &armnetwork.VirtualNetworkPropertiesFormat{
-			AddressSpace: &armnetwork.AddressSpace{
-				AddressPrefixes: []*string{&addressPrefix},
-			},
-		},
-	}, nil)
+	vnetPoller, err := vnetClient.BeginCreateOrUpdate(ctx, cfg.ResourceGroupName, vnetName, VnetParams(cfg), nil)
 	if err != nil {
 		ErrorLogger.Printf("Failed to begin virtual network creation: %v", err)
 		return armnetwork.VirtualNetworksClientCreateOrUpdateResponse{}, fmt.Errorf("failed to allocate virtual network: %v", err)
@@ -0,0 +1,326 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/costmanagement/armcostmanagement"
+)
+
+// Dimensions a cost report can be grouped by
+const (
+	GroupByResourceType = "ResourceType"
+	GroupByResourceID   = "ResourceId"
+	GroupByServiceName  = "ServiceName"
+)
+
+// Preset timeframes accepted by BillingPeriod
+const (
+	TimeframeMonthToDate = "month-to-date"
+	TimeframeLast7Days   = "last-7-days"
+	TimeframeLast30Days  = "last-30-days"
+)
+
+// costColumn is the aggregated cost column requested from Cost Management
+const costColumn = "PreTaxCost"
+
+// CostQuery selects the period and grouping of a cost report. From and To
+// are whole days, both included.
+type CostQuery struct {
+	From    time.Time
+	To      time.Time
+	GroupBy string
+}
+
+// CostRow is the cost of one group, such as one resource type
+type CostRow struct {
+	Group    string  `json:"group"`
+	Cost     float64 `json:"cost"`
+	Currency string  `json:"currency"`
+}
+
+// CostReport is the cost of the resource group over a period, per group
+type CostReport struct {
+	ResourceGroup string    `json:"resourceGroup"`
+	From          time.Time `json:"from"`
+	To            time.Time `json:"to"`
+	GroupBy       string    `json:"groupBy"`
+	Rows          []CostRow `json:"rows"`
+}
+
+// GroupByValues lists the supported groupings
+func GroupByValues() []string {
+	return []string{GroupByResourceType, GroupByResourceID, GroupByServiceName}
+}
+
+// TimeframeValues lists the supported preset timeframes
+func TimeframeValues() []string {
+	return []string{TimeframeMonthToDate, TimeframeLast7Days, TimeframeLast30Days}
+}
+
+// BillingPeriod returns the first and last day of a preset timeframe ending
+// on the day of now
+func BillingPeriod(timeframe string, now time.Time) (from, to time.Time, err error) {
+	today := now.UTC().Truncate(24 * time.Hour)
+	switch timeframe {
+	case TimeframeMonthToDate:
+		return time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC), today, nil
+	case TimeframeLast7Days:
+		return today.AddDate(0, 0, -6), today, nil
+	case TimeframeLast30Days:
+		return today.AddDate(0, 0, -29), today, nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("unknown timeframe %q, expected one of %s", timeframe, strings.Join(TimeframeValues(), ", "))
+}
+
+// GetBillingInfo queries Cost Management for the actual cost of the
+// configured resource group over the query's period
+func GetBillingInfo(ctx context.Context, cred azcore.TokenCredential, cfg *Config, query CostQuery) (*CostReport, error) {
+	groupBy, ok := lookupFold(GroupByValues(), query.GroupBy)
+	if !ok {
+		return nil, fmt.Errorf("cannot group costs by %q, expected one of %s", query.GroupBy, strings.Join(GroupByValues(), ", "))
+	}
+	query.GroupBy = groupBy
+	if query.To.Before(query.From) {
+		return nil, fmt.Errorf("the end of the period %s is before its start %s", query.To.Format(time.DateOnly), query.From.Format(time.DateOnly))
+	}
+	InfoLogger.Printf("Fetching costs of resource group %s from %s to %s by %s", cfg.ResourceGroupName, query.From.Format(time.DateOnly), query.To.Format(time.DateOnly), query.GroupBy)
+
+	result, err := queryCosts(ctx, cred, cfg, query.From, query.To, nil, query.GroupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CostReport{
+		ResourceGroup: cfg.ResourceGroupName,
+		From:          query.From,
+		To:            query.To,
+		GroupBy:       query.GroupBy,
+	}
+	if result == nil {
+		return report, nil
+	}
+
+	report.Rows, err = parseCostRows(result.Columns, result.Rows, query.GroupBy)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(report.Rows, func(i, j int) bool {
+		return report.Rows[i].Cost > report.Rows[j].Cost
+	})
+	return report, nil
+}
+
+// queryCosts runs an actual cost query over the days from and until, both
+// included, summing the cost per value of the grouping dimensions. A nil
+// granularity sums over the whole period.
+func queryCosts(ctx context.Context, cred azcore.TokenCredential, cfg *Config, from, until time.Time, granularity *armcostmanagement.GranularityType, groupBy ...string) (*armcostmanagement.QueryProperties, error) {
+	costClient, err := armcostmanagement.NewQueryClient(cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cost management client: %v", err)
+	}
+
+	var grouping []*armcostmanagement.QueryGrouping
+	for _, dimension := range groupBy {
+		grouping = append(grouping, &armcostmanagement.QueryGrouping{
+			Type: to.Ptr(armcostmanagement.QueryColumnTypeDimension),
+			Name: to.Ptr(dimension),
+		})
+	}
+
+	// The period runs to the end of the last day
+	end := until.Add(24*time.Hour - time.Second)
+	definition := armcostmanagement.QueryDefinition{
+		Type:      to.Ptr(armcostmanagement.ExportTypeActualCost),
+		Timeframe: to.Ptr(armcostmanagement.TimeframeTypeCustom),
+		TimePeriod: &armcostmanagement.QueryTimePeriod{
+			From: to.Ptr(from),
+			To:   to.Ptr(end),
+		},
+		Dataset: &armcostmanagement.QueryDataset{
+			Granularity: granularity,
+			Aggregation: map[string]*armcostmanagement.QueryAggregation{
+				"totalCost": {
+					Name:     to.Ptr(costColumn),
+					Function: to.Ptr(armcostmanagement.FunctionTypeSum),
+				},
+			},
+			Grouping: grouping,
+		},
+	}
+
+	result, err := costClient.Usage(ctx, ResourceGroupID(cfg), definition, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cost data: %v", err)
+	}
+	properties := result.Properties
+	if properties == nil || properties.NextLink == nil || *properties.NextLink == "" {
+		return properties, nil
+	}
+
+	// A long period grouped by resource spans several pages, an export
+	// without the later ones would be silently short
+	pageClient, err := arm.NewClient("azcommon", "v1.0.0", cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cost management client: %v", err)
+	}
+	for pages := 1; properties.NextLink != nil && *properties.NextLink != ""; pages++ {
+		InfoLogger.Printf("Fetching page %d of the cost data", pages+1)
+		page, err := nextCostsPage(ctx, pageClient, *properties.NextLink, definition)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get page %d of the cost data: %v", pages+1, err)
+		}
+		if page == nil {
+			break
+		}
+		properties.Rows = append(properties.Rows, page.Rows...)
+		properties.NextLink = page.NextLink
+	}
+	return properties, nil
+}
+
+// nextCostsPage fetches the page of a cost query at nextLink. The generated
+// client has no pager for queries, the next link is posted the same
+// definition as the first page.
+func nextCostsPage(ctx context.Context, client *arm.Client, nextLink string, definition armcostmanagement.QueryDefinition) (*armcostmanagement.QueryProperties, error) {
+	req, err := runtime.NewRequest(ctx, http.MethodPost, nextLink)
+	if err != nil {
+		return nil, err
+	}
+	req.Raw().Header["Accept"] = []string{"application/json"}
+	if err := runtime.MarshalAsJSON(req, definition); err != nil {
+		return nil, err
+	}
+	resp, err := client.Pipeline().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if !runtime.HasStatusCode(resp, http.StatusOK, http.StatusNoContent) {
+		return nil, runtime.NewResponseError(resp)
+	}
+	var page armcostmanagement.QueryResult
+	if err := runtime.UnmarshalAsJSON(resp, &page); err != nil {
+		return nil, err
+	}
+	return page.Properties, nil
+}
+
+// parseCostRows reads the group, cost and currency of each row, finding them
+// by column name since the column order is not part of the API contract
+func parseCostRows(columns []*armcostmanagement.QueryColumn, rows [][]any, groupBy string) ([]CostRow, error) {
+	groupIdx := columnIndex(columns, groupBy)
+	costIdx := columnIndex(columns, costColumn, "totalCost", "Cost")
+	currencyIdx := columnIndex(columns, "Currency")
+	if groupIdx < 0 || costIdx < 0 {
+		return nil, fmt.Errorf("cost data has no %s or cost column, got columns %s", groupBy, columnNames(columns))
+	}
+
+	var parsed []CostRow
+	for n, row := range rows {
+		if len(row) <= groupIdx || len(row) <= costIdx || (currencyIdx >= 0 && len(row) <= currencyIdx) {
+			return nil, fmt.Errorf("cost data row %d has %d values, expected %d", n, len(row), len(columns))
+		}
+		cost, ok := row[costIdx].(float64)
+		if !ok {
+			return nil, fmt.Errorf("cost data row %d has a non numeric cost %v", n, row[costIdx])
+		}
+		costRow := CostRow{Group: fmt.Sprint(row[groupIdx]), Cost: cost}
+		if currencyIdx >= 0 {
+			costRow.Currency = fmt.Sprint(row[currencyIdx])
+		}
+		if costRow.Group == "" {
+			costRow.Group = "(none)"
+		}
+		parsed = append(parsed, costRow)
+	}
+	return parsed, nil
+}
+
+// columnIndex returns the index of the first column called one of names,
+// ignoring case, or -1
+func columnIndex(columns []*armcostmanagement.QueryColumn, names ...string) int {
+	for i, column := range columns {
+		if column == nil || column.Name == nil {
+			continue
+		}
+		for _, name := range names {
+			if strings.EqualFold(*column.Name, name) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func columnNames(columns []*armcostmanagement.QueryColumn) string {
+	var names []string
+	for _, column := range columns {
+		if column != nil && column.Name != nil {
+			names = append(names, *column.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// lookupFold returns the entry of values matching value case-insensitively
+func lookupFold(values []string, value string) (string, bool) {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// Totals returns the total cost per currency
+func (r *CostReport) Totals() map[string]float64 {
+	totals := map[string]float64{}
+	for _, row := range r.Rows {
+		totals[row.Currency] += row.Cost
+	}
+	return totals
+}
+
+// WriteText writes the report as a table
+func (r *CostReport) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Billing Information for Resource Group: %s\n", r.ResourceGroup)
+	fmt.Fprintln(w, "============================================")
+	fmt.Fprintf(w, "Time Period: %s to %s\n\n", r.From.Format(time.DateOnly), r.To.Format(time.DateOnly))
+
+	if len(r.Rows) == 0 {
+		fmt.Fprintln(w, "No cost data available for the specified time period.")
+		return
+	}
+
+	fmt.Fprintf(w, "Cost Breakdown by %s:\n", r.GroupBy)
+	fmt.Fprintln(w, "--------------------------------------------")
+	for _, row := range r.Rows {
+		group := row.Group
+		if r.GroupBy == GroupByResourceID {
+			group = shortID(group)
+		}
+		fmt.Fprintf(w, "%-40s %10.2f %s\n", group, row.Cost, row.Currency)
+	}
+	fmt.Fprintln(w, "--------------------------------------------")
+
+	totals := r.Totals()
+	currencies := make([]string, 0, len(totals))
+	for currency := range totals {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	for _, currency := range currencies {
+		fmt.Fprintf(w, "%-40s %10.2f %s\n", "Total", totals[currency], currency)
+	}
+
+	fmt.Fprintln(w, "\nNote: Cost data can lag behind actual usage by up to a day.")
+}
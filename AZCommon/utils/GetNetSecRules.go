@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+// ListNetSecRules returns the security rules currently in the configured NSG
+func ListNetSecRules(ctx context.Context, cred azcore.TokenCredential, cfg *Config) ([]*armnetwork.SecurityRule, error) {
+	securityRulesClient, err := armnetwork.NewSecurityRulesClient(cfg.SubscriptionID, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create security rules client: %v", err)
+	}
+
+	var rules []*armnetwork.SecurityRule
+	pager := securityRulesClient.NewListPager(cfg.ResourceGroupName, cfg.NSGName, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list security rules of NSG %s: %v", cfg.NSGName, err)
+		}
+		rules = append(rules, page.Value...)
+	}
+	return rules, nil
+}
@@ -3,11 +3,9 @@ package utils
 import (
 	"context"
 	"fmt"
-	"os"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
-	"github.com/joho/godotenv"
 )
 
 // func printExit(err error, message string) {
@@ -17,15 +15,12 @@ import (
 // 	}
 // }
 
-func GetRg() {
-	err := godotenv.Load()
-	PrintExit(err, "Error Loading .env file")
+// GetRg lists every resource group in the configured subscription
+func GetRg(cred azcore.TokenCredential, cfg *Config) {
 	ctx := context.Background()
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
-	PrintExit(err, "Failed to obtain a credential")
 
 	// Create a resource groups client
-	rgClient, err := armresources.NewResourceGroupsClient(os.Getenv("AZURE_SUBSCRIPTION_ID"), cred, nil)
+	rgClient, err := armresources.NewResourceGroupsClient(cfg.SubscriptionID, cred, nil)
 	PrintExit(err, "Failed to create resource groups client")
 
 	// List all resource groups
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+)
+
+// GetVMPowerState returns the power state of the configured VM, such as
+// "running" or "deallocated", or "not found" if the VM does not exist
+func GetVMPowerState(ctx context.Context, cred azcore.TokenCredential, cfg *Config) (string, error) {
+	vmClient, err := armcompute.NewVirtualMachinesClient(cfg.SubscriptionID, cred, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create VM client: %v", err)
+	}
+
+	view, err := vmClient.InstanceView(ctx, cfg.ResourceGroupName, cfg.VMName, nil)
+	if isNotFound(err) {
+		return "not found", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get instance view of VM %s: %v", cfg.VMName, err)
+	}
+
+	for _, status := range view.Statuses {
+		if status.Code != nil && strings.HasPrefix(*status.Code, "PowerState/") {
+			return strings.TrimPrefix(*status.Code, "PowerState/"), nil
+		}
+	}
+	return "unknown", nil
+}
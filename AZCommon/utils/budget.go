@@ -0,0 +1,280 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/consumption/armconsumption"
+)
+
+// TypeBudget is the resource type of consumption budgets
+const TypeBudget = "Microsoft.Consumption/budgets"
+
+// DefaultBudgetThresholds are the alert thresholds, in percent of the
+// budget, used when BUDGET_THRESHOLDS is not set
+const DefaultBudgetThresholds = "80,100"
+
+// maxBudgetNotifications is the number of notifications Azure allows on a
+// budget
+const maxBudgetNotifications = 5
+
+// BudgetSettings is the monthly budget configured for the resource group
+type BudgetSettings struct {
+	Name          string    `json:"name"`
+	Amount        float64   `json:"amount"`
+	Thresholds    []float64 `json:"thresholds"`
+	ContactEmails []string  `json:"contactEmails"`
+}
+
+// Budget returns the configured budget, or nil when BUDGET_AMOUNT is not set
+func (c *Config) Budget() (*BudgetSettings, error) {
+	if c.BudgetAmount == "" {
+		return nil, nil
+	}
+	if problems := c.validateBudget(); len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+
+	amount, _ := strconv.ParseFloat(c.BudgetAmount, 64)
+	settings := &BudgetSettings{
+		Name:          c.BudgetName,
+		Amount:        amount,
+		ContactEmails: splitList(c.BudgetContactEmails),
+	}
+	if settings.Name == "" {
+		settings.Name = c.ResourceGroupName + "-budget"
+	}
+	for _, value := range splitList(c.budgetThresholds()) {
+		threshold, _ := strconv.ParseFloat(value, 64)
+		settings.Thresholds = append(settings.Thresholds, threshold)
+	}
+	sort.Float64s(settings.Thresholds)
+	return settings, nil
+}
+
+func (c *Config) budgetThresholds() string {
+	if c.BudgetThresholds == "" {
+		return DefaultBudgetThresholds
+	}
+	return c.BudgetThresholds
+}
+
+// validateBudget checks the budget settings, which are only required once
+// BUDGET_AMOUNT is set
+func (c *Config) validateBudget() []string {
+	if c.BudgetAmount == "" {
+		if c.BudgetThresholds != "" || c.BudgetContactEmails != "" {
+			return []string{"BUDGET_THRESHOLDS and BUDGET_CONTACT_EMAILS need BUDGET_AMOUNT"}
+		}
+		return nil
+	}
+
+	var problems []string
+	if amount, err := strconv.ParseFloat(c.BudgetAmount, 64); err != nil || amount <= 0 {
+		problems = append(problems, fmt.Sprintf("BUDGET_AMOUNT %q is not a positive amount", c.BudgetAmount))
+	}
+
+	thresholds := splitList(c.budgetThresholds())
+	if len(thresholds) == 0 || len(thresholds) > maxBudgetNotifications {
+		problems = append(problems, fmt.Sprintf("BUDGET_THRESHOLDS must list 1 to %d percentages", maxBudgetNotifications))
+	}
+	seen := map[float64]bool{}
+	for _, value := range thresholds {
+		threshold, err := strconv.ParseFloat(value, 64)
+		if err != nil || threshold <= 0 || threshold > 1000 {
+			problems = append(problems, fmt.Sprintf("BUDGET_THRESHOLDS value %q is not a percentage between 0 and 1000", value))
+			continue
+		}
+		if seen[threshold] {
+			problems = append(problems, fmt.Sprintf("BUDGET_THRESHOLDS lists %s more than once", value))
+		}
+		seen[threshold] = true
+	}
+
+	emails := splitList(c.BudgetContactEmails)
+	if len(emails) == 0 {
+		problems = append(problems, "BUDGET_CONTACT_EMAILS is required when BUDGET_AMOUNT is set")
+	}
+	for _, email := range emails {
+		if _, err := mail.ParseAddress(email); err != nil {
+			problems = append(problems, fmt.Sprintf("BUDGET_CONTACT_EMAILS entry %q is not an email address", email))
+		}
+	}
+	return problems
+}
+
+// splitList splits a comma separated setting, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// BudgetParams builds the monthly cost budget starting at start, which must
+// be the first of a month, with an actual cost alert per threshold
+func BudgetParams(settings *BudgetSettings, start time.Time) armconsumption.Budget {
+	notifications := map[string]*armconsumption.Notification{}
+	for _, threshold := range settings.Thresholds {
+		key := "Actual_GreaterThan_" + strings.ReplaceAll(strconv.FormatFloat(threshold, 'f', -1, 64), ".", "_") + "_Percent"
+		notifications[key] = &armconsumption.Notification{
+			Enabled:       to.Ptr(true),
+			Operator:      to.Ptr(armconsumption.OperatorTypeGreaterThan),
+			Threshold:     to.Ptr(threshold),
+			ThresholdType: to.Ptr(armconsumption.ThresholdTypeActual),
+			ContactEmails: to.SliceOfPtrs(settings.ContactEmails...),
+		}
+	}
+
+	return armconsumption.Budget{
+		Properties: &armconsumption.BudgetProperties{
+			Amount:    to.Ptr(settings.Amount),
+			Category:  to.Ptr(armconsumption.CategoryTypeCost),
+			TimeGrain: to.Ptr(armconsumption.TimeGrainTypeMonthly),
+			TimePeriod: &armconsumption.BudgetTimePeriod{
+				StartDate: to.Ptr(start),
+			},
+			Notifications: notifications,
+		},
+	}
+}
+
+// budgetStart is the start date of a new budget, the first of the month
+func budgetStart(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// CreateBudget creates the configured budget on the resource group or
+// updates it to match the configuration
+func CreateBudget(ctx context.Context, cred azcore.TokenCredential, cfg *Config) (*armconsumption.Budget, error) {
+	settings, err := cfg.Budget()
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		return nil, fmt.Errorf("no budget configured, set BUDGET_AMOUNT")
+	}
+
+	budgetsClient, err := armconsumption.NewBudgetsClient(cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create budgets client: %v", err)
+	}
+	scope := ResourceGroupID(cfg)
+
+	budget := BudgetParams(settings, budgetStart(time.Now()))
+	existing, err := budgetsClient.Get(ctx, scope, settings.Name, nil)
+	switch {
+	case err == nil:
+		// Updates must carry the current eTag and keep the start date
+		InfoLogger.Printf("Updating budget %s", settings.Name)
+		budget.ETag = existing.ETag
+		if existing.Properties != nil && existing.Properties.TimePeriod != nil {
+			budget.Properties.TimePeriod = existing.Properties.TimePeriod
+		}
+	case isNotFound(err):
+		InfoLogger.Printf("Creating budget %s", settings.Name)
+	default:
+		return nil, fmt.Errorf("failed to get budget %s: %v", settings.Name, err)
+	}
+
+	result, err := budgetsClient.CreateOrUpdate(ctx, scope, settings.Name, budget, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save budget %s: %v", settings.Name, err)
+	}
+	InfoLogger.Printf("Budget %s set to %.2f per month with alerts at %s", settings.Name, settings.Amount, formatPercentages(settings.Thresholds))
+	return &result.Budget, nil
+}
+
+// GetBudget returns the configured budget with its current spend
+func GetBudget(ctx context.Context, cred azcore.TokenCredential, cfg *Config) (*armconsumption.Budget, error) {
+	settings, err := cfg.Budget()
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		return nil, fmt.Errorf("no budget configured, set BUDGET_AMOUNT")
+	}
+
+	budgetsClient, err := armconsumption.NewBudgetsClient(cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create budgets client: %v", err)
+	}
+	result, err := budgetsClient.Get(ctx, ResourceGroupID(cfg), settings.Name, nil)
+	if isNotFound(err) {
+		return nil, fmt.Errorf("budget %s does not exist yet, it is created by deploy", settings.Name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get budget %s: %v", settings.Name, err)
+	}
+	return &result.Budget, nil
+}
+
+// WriteBudgetStatus writes the current spend of a budget against its amount
+// and alert thresholds
+func WriteBudgetStatus(w io.Writer, budget *armconsumption.Budget) {
+	props := budget.Properties
+	name := ""
+	if budget.Name != nil {
+		name = *budget.Name
+	}
+	fmt.Fprintf(w, "Budget: %s\n", name)
+	fmt.Fprintln(w, "============================================")
+	if props == nil || props.Amount == nil {
+		fmt.Fprintln(w, "No budget details available.")
+		return
+	}
+
+	var spent float64
+	unit := ""
+	if props.CurrentSpend != nil {
+		if props.CurrentSpend.Amount != nil {
+			spent = *props.CurrentSpend.Amount
+		}
+		if props.CurrentSpend.Unit != nil {
+			unit = *props.CurrentSpend.Unit
+		}
+	}
+	if props.TimeGrain != nil {
+		fmt.Fprintf(w, "Time grain:    %s\n", *props.TimeGrain)
+	}
+	fmt.Fprintf(w, "Amount:        %.2f %s\n", *props.Amount, unit)
+	fmt.Fprintf(w, "Current spend: %.2f %s (%.1f%%)\n", spent, unit, spent / *props.Amount * 100)
+	if props.ForecastSpend != nil && props.ForecastSpend.Amount != nil {
+		fmt.Fprintf(w, "Forecast:      %.2f %s\n", *props.ForecastSpend.Amount, unit)
+	}
+
+	var thresholds []float64
+	for _, notification := range props.Notifications {
+		if notification != nil && notification.Threshold != nil {
+			thresholds = append(thresholds, *notification.Threshold)
+		}
+	}
+	sort.Float64s(thresholds)
+	for _, threshold := range thresholds {
+		status := "not reached"
+		if spent >= *props.Amount*threshold/100 {
+			status = "REACHED"
+		}
+		fmt.Fprintf(w, "Alert at %6.1f%% (%.2f %s): %s\n", threshold, *props.Amount*threshold/100, unit, status)
+	}
+}
+
+func formatPercentages(values []float64) string {
+	var parts []string
+	for _, v := range values {
+		parts = append(parts, strconv.FormatFloat(v, 'f', -1, 64)+"%")
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CloudConfigHeader is the first line cloud-init needs to read a document as
+// cloud-config
+const CloudConfigHeader = "#cloud-config"
+
+// MaxCustomDataSize is the most base64 encoded custom data Azure accepts
+const MaxCustomDataSize = 64 * 1024
+
+// CloudConfig is a cloud-init document generated by the tool, see
+// Profile.CloudInit. Only the modules the VPN servers need are modelled.
+type CloudConfig struct {
+	PackageUpdate  bool        `yaml:"package_update,omitempty"`
+	PackageUpgrade bool        `yaml:"package_upgrade,omitempty"`
+	Packages       []string    `yaml:"packages,omitempty"`
+	WriteFiles     []WriteFile `yaml:"write_files,omitempty"`
+	// RunCmd lists shell commands run once on first boot, in order
+	RunCmd []string `yaml:"runcmd,omitempty"`
+}
+
+// WriteFile is a file written by cloud-init before the packages are installed
+type WriteFile struct {
+	Path        string `yaml:"path"`
+	Content     string `yaml:"content"`
+	Owner       string `yaml:"owner,omitempty"`
+	Permissions string `yaml:"permissions,omitempty"`
+}
+
+// Render returns the document with its #cloud-config header
+func (c *CloudConfig) Render() ([]byte, error) {
+	body, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render cloud-init: %v", err)
+	}
+	return append([]byte(CloudConfigHeader+"\n"), body...), nil
+}
+
+// CloudInit returns the cloud-init document for the VM: the one generated
+// by the profile, the CLOUD_INIT_FILE, or both merged. In a merge the lists
+// of the file, such as packages and runcmd, are appended to the generated
+// ones and its other settings win. It returns nil when there is neither.
+// dryRun is passed on to Profile.CloudInit.
+func (c *Config) CloudInit(profile Profile, dryRun bool) ([]byte, error) {
+	var generated []byte
+	if profile.CloudInit != nil {
+		doc, err := profile.CloudInit(c, profile, dryRun)
+		if err != nil {
+			return nil, err
+		}
+		if doc != nil {
+			if generated, err = doc.Render(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if c.CloudInitFile == "" {
+		if generated == nil {
+			return nil, nil
+		}
+		return generated, ValidateCloudInit(generated)
+	}
+	file, err := os.ReadFile(c.CloudInitFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CLOUD_INIT_FILE: %v", err)
+	}
+	if err := ValidateCloudInit(file); err != nil {
+		return nil, fmt.Errorf("CLOUD_INIT_FILE %s: %v", c.CloudInitFile, err)
+	}
+	if generated == nil {
+		return file, nil
+	}
+
+	merged, err := mergeCloudInit(generated, file)
+	if err != nil {
+		return nil, err
+	}
+	return merged, ValidateCloudInit(merged)
+}
+
+// mergeCloudInit layers the file over the generated document
+func mergeCloudInit(generated, file []byte) ([]byte, error) {
+	var base, overlay map[string]any
+	if err := yaml.Unmarshal(generated, &base); err != nil {
+		return nil, fmt.Errorf("failed to parse generated cloud-init: %v", err)
+	}
+	if err := yaml.Unmarshal(file, &overlay); err != nil {
+		return nil, fmt.Errorf("failed to parse CLOUD_INIT_FILE: %v", err)
+	}
+	for key, value := range overlay {
+		baseList, baseIsList := base[key].([]any)
+		list, isList := value.([]any)
+		if baseIsList && isList {
+			base[key] = append(baseList, list...)
+			continue
+		}
+		base[key] = value
+	}
+	body, err := yaml.Marshal(base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render cloud-init: %v", err)
+	}
+	return append([]byte(CloudConfigHeader+"\n"), body...), nil
+}
+
+// ValidateCloudInit checks that a document starts with the #cloud-config
+// header, parses as a YAML mapping and fits in Azure's custom data limit
+func ValidateCloudInit(doc []byte) error {
+	firstLine, _, _ := bytes.Cut(doc, []byte("\n"))
+	if strings.TrimSpace(string(firstLine)) != CloudConfigHeader {
+		return fmt.Errorf("cloud-init must start with a %s line", CloudConfigHeader)
+	}
+	var settings map[string]any
+	if err := yaml.Unmarshal(doc, &settings); err != nil {
+		return fmt.Errorf("cloud-init is not valid YAML: %v", err)
+	}
+	if len(settings) == 0 {
+		return fmt.Errorf("cloud-init has no settings")
+	}
+	if size := base64.StdEncoding.EncodedLen(len(doc)); size > MaxCustomDataSize {
+		return fmt.Errorf("cloud-init is %d bytes base64 encoded, more than the %d Azure accepts", size, MaxCustomDataSize)
+	}
+	return nil
+}
+
+// CustomData returns the cloud-init document base64 encoded for
+// OSProfile.CustomData, or "" when the VM has none
+func CustomData(cfg *Config, profile Profile, dryRun bool) (string, error) {
+	doc, err := cfg.CloudInit(profile, dryRun)
+	if err != nil || doc == nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(doc), nil
+}
+
+// RedactCustomData replaces custom data, which holds the server keys, with
+// its size and SHA-256 hash, so a plan can be shared and still tells whether
+// the cloud-init changed
+func RedactCustomData(customData string) string {
+	if customData == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(customData))
+	return fmt.Sprintf("redacted, %d bytes base64 encoded, sha256 %s", len(customData), hex.EncodeToString(sum[:]))
+}
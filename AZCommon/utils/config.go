@@ -0,0 +1,360 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting a deployment needs. Each field can be set from
+// the environment (and the .env file), from a YAML or JSON config file, or
+// from a --set KEY=VALUE override, in increasing order of precedence.
+// The env tag doubles as the key accepted by --set. Fields tagged
+// optional:"true" may be left empty.
+type Config struct {
+	SubscriptionID    string `env:"AZURE_SUBSCRIPTION_ID" json:"subscriptionId,omitempty" yaml:"subscriptionId,omitempty"`
+	ResourceGroupName string `env:"RESOURCE_GROUP_NAME" json:"resourceGroupName,omitempty" yaml:"resourceGroupName,omitempty"`
+	Location          string `env:"VM_LOCATION" json:"location,omitempty" yaml:"location,omitempty"`
+
+	// VM settings
+	VMName           string `env:"VM_NAME" json:"vmName,omitempty" yaml:"vmName,omitempty"`
+	PublisherName    string `env:"PUBLISHER_NAME" json:"publisherName,omitempty" yaml:"publisherName,omitempty"`
+	Offer            string `env:"OFFER" json:"offer,omitempty" yaml:"offer,omitempty"`
+	SKU              string `env:"SKU" json:"sku,omitempty" yaml:"sku,omitempty"`
+	VMVersion        string `env:"VM_VERSION" json:"vmVersion,omitempty" yaml:"vmVersion,omitempty"`
+	AdminUsername    string `env:"ADMIN_USERNAME" json:"adminUsername,omitempty" yaml:"adminUsername,omitempty"`
+	SSHPubKeyPath    string `env:"SSH_PUB_KEY_PATH" json:"sshPubKeyPath,omitempty" yaml:"sshPubKeyPath,omitempty"`
+	SSHPubKeyContent string `env:"SSH_PUB_KEY_CONTENT" json:"sshPubKeyContent,omitempty" yaml:"sshPubKeyContent,omitempty"`
+	// CloudInitFile is a #cloud-config document passed to the VM as custom
+	// data, merged with the one the flavor generates, see Config.CloudInit
+	CloudInitFile string `env:"CLOUD_INIT_FILE" json:"cloudInitFile,omitempty" yaml:"cloudInitFile,omitempty" optional:"true"`
+
+	// Network settings
+	VnetName      string `env:"VNET_NAME" json:"vnetName,omitempty" yaml:"vnetName,omitempty"`
+	AddressPrefix string `env:"ADDRESS_PREFIX" json:"addressPrefix,omitempty" yaml:"addressPrefix,omitempty"`
+	SubnetName    string `env:"SUBNET_NAME" json:"subnetName,omitempty" yaml:"subnetName,omitempty"`
+	SubnetPrefix  string `env:"SUBNET_PREFIX" json:"subnetPrefix,omitempty" yaml:"subnetPrefix,omitempty"`
+	PublicIPName  string `env:"PUBLIC_IP_NAME" json:"publicIpName,omitempty" yaml:"publicIpName,omitempty"`
+	NICName       string `env:"NIC_NAME" json:"nicName,omitempty" yaml:"nicName,omitempty"`
+	// Dual stack, see DualStack. Setting ADDRESS_PREFIX_V6 adds IPv6 to the
+	// vnet, subnet and NIC with a second public IP.
+	AddressPrefixV6 string `env:"ADDRESS_PREFIX_V6" json:"addressPrefixV6,omitempty" yaml:"addressPrefixV6,omitempty" optional:"true"`
+	SubnetPrefixV6  string `env:"SUBNET_PREFIX_V6" json:"subnetPrefixV6,omitempty" yaml:"subnetPrefixV6,omitempty" optional:"true"`
+	PublicIPV6Name  string `env:"PUBLIC_IP_V6_NAME" json:"publicIpV6Name,omitempty" yaml:"publicIpV6Name,omitempty" optional:"true"`
+
+	// NSG settings. NSGAttach is nic (the default), subnet or both.
+	NSGName   string `env:"NSG_NAME" json:"nsgName,omitempty" yaml:"nsgName,omitempty"`
+	NSGAttach string `env:"NSG_ATTACH" json:"nsgAttach,omitempty" yaml:"nsgAttach,omitempty" optional:"true"`
+	// Rules replaces the default NSG rules, see RuleSpec. It can only be set
+	// from a config file.
+	Rules []RuleSpec `json:"rules,omitempty" yaml:"rules,omitempty"`
+
+	// Management access, see LockDown. MANAGEMENT_SOURCES lists the CIDRs
+	// allowed to reach MANAGEMENT_PORTS (default 22), "myip" standing for
+	// the caller's public IP as reported by IP_RESOLVER_URL.
+	ManagementSources string `env:"MANAGEMENT_SOURCES" json:"managementSources,omitempty" yaml:"managementSources,omitempty" optional:"true"`
+	ManagementPorts   string `env:"MANAGEMENT_PORTS" json:"managementPorts,omitempty" yaml:"managementPorts,omitempty" optional:"true"`
+	IPResolverURL     string `env:"IP_RESOLVER_URL" json:"ipResolverUrl,omitempty" yaml:"ipResolverUrl,omitempty" optional:"true"`
+
+	// Outbound policy, see egressRuleSpecs. EGRESS_ALLOW lists presets,
+	// EGRESS_VPN_DESTINATIONS the CIDRs or service tags VPN clients may reach
+	// and DEFAULT_DENY adds deny-all rules: inbound, outbound or both.
+	EgressAllow           string `env:"EGRESS_ALLOW" json:"egressAllow,omitempty" yaml:"egressAllow,omitempty" optional:"true"`
+	EgressVPNDestinations string `env:"EGRESS_VPN_DESTINATIONS" json:"egressVpnDestinations,omitempty" yaml:"egressVpnDestinations,omitempty" optional:"true"`
+	DefaultDeny           string `env:"DEFAULT_DENY" json:"defaultDeny,omitempty" yaml:"defaultDeny,omitempty" optional:"true"`
+
+	// VPN server settings, see VPNPort. VPN_PORT overrides the flavor's
+	// port, TUNNEL_CIDR is the client network, whose traffic is masqueraded
+	// behind NAT_INTERFACE.
+	VPNPortOverride  string `env:"VPN_PORT" json:"vpnPort,omitempty" yaml:"vpnPort,omitempty" optional:"true"`
+	TunnelCIDR       string `env:"TUNNEL_CIDR" json:"tunnelCidr,omitempty" yaml:"tunnelCidr,omitempty" optional:"true"`
+	NATInterfaceName string `env:"NAT_INTERFACE" json:"natInterface,omitempty" yaml:"natInterface,omitempty" optional:"true"`
+	// ClientDNSServers are the resolvers written into client configs
+	ClientDNSServers string `env:"CLIENT_DNS" json:"clientDns,omitempty" yaml:"clientDns,omitempty" optional:"true"`
+
+	// OpenVPN PKI settings, see LoadOpenVPNPKI. PKI_KEY_TYPE is ecdsa or
+	// rsa, PKI_CA_DAYS and PKI_CERT_DAYS the validity of the CA and of the
+	// certificates it issues.
+	PKIKeyType  string `env:"PKI_KEY_TYPE" json:"pkiKeyType,omitempty" yaml:"pkiKeyType,omitempty" optional:"true"`
+	PKICADays   string `env:"PKI_CA_DAYS" json:"pkiCaDays,omitempty" yaml:"pkiCaDays,omitempty" optional:"true"`
+	PKICertDays string `env:"PKI_CERT_DAYS" json:"pkiCertDays,omitempty" yaml:"pkiCertDays,omitempty" optional:"true"`
+
+	// Authentication settings, see NewCredential
+	AuthMethod                string `env:"AUTH_METHOD" json:"authMethod,omitempty" yaml:"authMethod,omitempty" optional:"true"`
+	TenantID                  string `env:"AZURE_TENANT_ID" json:"tenantId,omitempty" yaml:"tenantId,omitempty" optional:"true"`
+	ClientID                  string `env:"AZURE_CLIENT_ID" json:"clientId,omitempty" yaml:"clientId,omitempty" optional:"true"`
+	ClientSecret              string `env:"AZURE_CLIENT_SECRET" json:"clientSecret,omitempty" yaml:"clientSecret,omitempty" optional:"true"`
+	ClientCertificatePath     string `env:"AZURE_CLIENT_CERTIFICATE_PATH" json:"clientCertificatePath,omitempty" yaml:"clientCertificatePath,omitempty" optional:"true"`
+	ClientCertificatePassword string `env:"AZURE_CLIENT_CERTIFICATE_PASSWORD" json:"clientCertificatePassword,omitempty" yaml:"clientCertificatePassword,omitempty" optional:"true"`
+
+	// DeleteTimeout bounds how long to wait for deletions to propagate, as a
+	// Go duration such as "10m". Defaults to DefaultDeleteTimeout.
+	DeleteTimeout string `env:"DELETE_TIMEOUT" json:"deleteTimeout,omitempty" yaml:"deleteTimeout,omitempty" optional:"true"`
+
+	// Budget settings, see Budget. No budget is created without BUDGET_AMOUNT.
+	BudgetName          string `env:"BUDGET_NAME" json:"budgetName,omitempty" yaml:"budgetName,omitempty" optional:"true"`
+	BudgetAmount        string `env:"BUDGET_AMOUNT" json:"budgetAmount,omitempty" yaml:"budgetAmount,omitempty" optional:"true"`
+	BudgetThresholds    string `env:"BUDGET_THRESHOLDS" json:"budgetThresholds,omitempty" yaml:"budgetThresholds,omitempty" optional:"true"`
+	BudgetContactEmails string `env:"BUDGET_CONTACT_EMAILS" json:"budgetContactEmails,omitempty" yaml:"budgetContactEmails,omitempty" optional:"true"`
+}
+
+// LoadConfig builds a Config from the .env file and environment, then layers
+// the given YAML/JSON files and KEY=VALUE overrides on top. It does not
+// validate the result, call Validate for that.
+func LoadConfig(files []string, overrides []string) (*Config, error) {
+	// A missing .env is fine as long as the values come from somewhere else
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load .env file: %v", err)
+	}
+
+	cfg := &Config{}
+	cfg.forEachField(func(key string, field reflect.Value) {
+		field.SetString(os.Getenv(key))
+	})
+
+	for _, file := range files {
+		InfoLogger.Printf("Loading config file %s", file)
+		fileCfg, err := readConfigFile(file)
+		if err != nil {
+			return nil, err
+		}
+		cfg.merge(fileCfg)
+	}
+
+	for _, override := range overrides {
+		key, value, ok := strings.Cut(override, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid override %q, expected KEY=VALUE", override)
+		}
+		if err := cfg.Set(key, value); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// Set assigns a single setting by its environment variable name
+func (c *Config) Set(key, value string) error {
+	found := false
+	c.forEachField(func(name string, field reflect.Value) {
+		if name == strings.ToUpper(key) {
+			field.SetString(value)
+			found = true
+		}
+	})
+	if !found {
+		return fmt.Errorf("unknown config key %q", key)
+	}
+	return nil
+}
+
+// Keys returns the environment variable names of every setting, sorted
+func (c *Config) Keys() []string {
+	var keys []string
+	c.forEachField(func(name string, _ reflect.Value) {
+		keys = append(keys, name)
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// forEachField calls fn for every string field tagged with an env name
+func (c *Config) forEachField(fn func(key string, field reflect.Value)) {
+	c.forEachTaggedField(func(key string, field reflect.Value, _ reflect.StructTag) {
+		fn(key, field)
+	})
+}
+
+func (c *Config) forEachTaggedField(fn func(key string, field reflect.Value, tag reflect.StructTag)) {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag
+		key := tag.Get("env")
+		if key == "" || v.Field(i).Kind() != reflect.String {
+			continue
+		}
+		fn(key, v.Field(i), tag)
+	}
+}
+
+// merge copies every non-empty field of other into c
+func (c *Config) merge(other *Config) {
+	dst := reflect.ValueOf(c).Elem()
+	src := reflect.ValueOf(other).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		if !src.Field(i).IsZero() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+}
+
+func readConfigFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+
+	cfg := &Config{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".json":
+		err = json.Unmarshal(data, cfg)
+	default:
+		return nil, fmt.Errorf("unsupported config file %s, expected .yaml, .yml or .json", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// ValidationError lists every problem found in a Config
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration:\n  - %s", strings.Join(e.Problems, "\n  - "))
+}
+
+var locationPattern = regexp.MustCompile(`^[a-z][a-z0-9]+$`)
+
+// Validate checks the whole Config and reports every problem at once, so a
+// bad value fails here instead of as an Azure 400 halfway through a deploy
+func (c *Config) Validate() error {
+	var problems []string
+
+	c.forEachTaggedField(func(key string, field reflect.Value, tag reflect.StructTag) {
+		if tag.Get("optional") != "true" && strings.TrimSpace(field.String()) == "" {
+			problems = append(problems, fmt.Sprintf("%s is required", key))
+		}
+	})
+
+	addressPrefix, addressErr := parsePrefix("ADDRESS_PREFIX", c.AddressPrefix)
+	if addressErr != "" {
+		problems = append(problems, addressErr)
+	}
+	subnetPrefix, subnetErr := parsePrefix("SUBNET_PREFIX", c.SubnetPrefix)
+	if subnetErr != "" {
+		problems = append(problems, subnetErr)
+	}
+	if addressPrefix.IsValid() && subnetPrefix.IsValid() && !prefixContains(addressPrefix, subnetPrefix) {
+		problems = append(problems, fmt.Sprintf("SUBNET_PREFIX %s is not inside ADDRESS_PREFIX %s", subnetPrefix, addressPrefix))
+	}
+
+	if c.Location != "" && !locationPattern.MatchString(c.Location) {
+		problems = append(problems, fmt.Sprintf("VM_LOCATION %q is not a location name, expected something like \"eastus\" or \"westeurope\"", c.Location))
+	}
+
+	if c.SSHPubKeyContent != "" {
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(c.SSHPubKeyContent)); err != nil {
+			problems = append(problems, fmt.Sprintf("SSH_PUB_KEY_CONTENT is not a valid SSH public key: %v", err))
+		}
+	}
+
+	if c.CloudInitFile != "" {
+		if doc, err := os.ReadFile(c.CloudInitFile); err != nil {
+			problems = append(problems, fmt.Sprintf("CLOUD_INIT_FILE cannot be read: %v", err))
+		} else if err := ValidateCloudInit(doc); err != nil {
+			problems = append(problems, fmt.Sprintf("CLOUD_INIT_FILE %s: %v", c.CloudInitFile, err))
+		}
+	}
+
+	if c.DeleteTimeout != "" {
+		if timeout, err := time.ParseDuration(c.DeleteTimeout); err != nil || timeout <= 0 {
+			problems = append(problems, fmt.Sprintf("DELETE_TIMEOUT %q is not a positive duration such as \"10m\"", c.DeleteTimeout))
+		}
+	}
+
+	if c.NSGAttach != "" {
+		if value, _ := lookupFold(NSGAttachValues(), c.NSGAttach); value != c.NSGAttach {
+			problems = append(problems, fmt.Sprintf("NSG_ATTACH %q is not one of %s", c.NSGAttach, strings.Join(NSGAttachValues(), ", ")))
+		}
+	}
+
+	problems = append(problems, c.validateIPv6()...)
+	problems = append(problems, c.validateVPN()...)
+	problems = append(problems, c.validateClients()...)
+	problems = append(problems, c.validatePKI()...)
+	problems = append(problems, c.validateAuth()...)
+	problems = append(problems, c.validateBudget()...)
+	problems = append(problems, ValidateRuleSpecs(c.Rules)...)
+	problems = append(problems, c.validateManagement()...)
+	problems = append(problems, c.validateEgress()...)
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// ValidateSubscription checks only what talking to the subscription needs,
+// AZURE_SUBSCRIPTION_ID and the authentication settings, for commands such
+// as list that do not touch a deployment
+func (c *Config) ValidateSubscription() error {
+	var problems []string
+	if strings.TrimSpace(c.SubscriptionID) == "" {
+		problems = append(problems, "AZURE_SUBSCRIPTION_ID is required")
+	}
+	problems = append(problems, c.validateAuth()...)
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// ValidateBilling checks only what a cost report on the resource group
+// needs, the subscription and authentication settings and
+// RESOURCE_GROUP_NAME, with the budget settings when withBudget is set
+func (c *Config) ValidateBilling(withBudget bool) error {
+	var problems []string
+	if strings.TrimSpace(c.SubscriptionID) == "" {
+		problems = append(problems, "AZURE_SUBSCRIPTION_ID is required")
+	}
+	if strings.TrimSpace(c.ResourceGroupName) == "" {
+		problems = append(problems, "RESOURCE_GROUP_NAME is required")
+	}
+	problems = append(problems, c.validateAuth()...)
+	if withBudget {
+		problems = append(problems, c.validateBudget()...)
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// parsePrefix parses a CIDR and returns a problem description if it is not
+// valid or not in canonical form (Azure rejects host bits in a prefix)
+func parsePrefix(key, value string) (netip.Prefix, string) {
+	if value == "" {
+		return netip.Prefix{}, ""
+	}
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Sprintf("%s %q is not a valid CIDR", key, value)
+	}
+	if prefix.Masked() != prefix {
+		return netip.Prefix{}, fmt.Sprintf("%s %q has host bits set, did you mean %s?", key, value, prefix.Masked())
+	}
+	return prefix, ""
+}
+
+// prefixContains reports whether inner lies completely inside outer
+func prefixContains(outer, inner netip.Prefix) bool {
+	return outer.Bits() <= inner.Bits() && outer.Contains(inner.Addr())
+}
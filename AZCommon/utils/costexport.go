@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/costmanagement/armcostmanagement"
+)
+
+// CostRecord is the cost of one meter category of one resource on one day
+type CostRecord struct {
+	Date          string  `json:"date"`
+	Resource      string  `json:"resource"`
+	MeterCategory string  `json:"meterCategory"`
+	Cost          float64 `json:"cost"`
+	Currency      string  `json:"currency"`
+}
+
+// GetCostRecords returns the daily cost of every resource and meter
+// category in the configured resource group over the query's period
+func GetCostRecords(ctx context.Context, cred azcore.TokenCredential, cfg *Config, query CostQuery) ([]CostRecord, error) {
+	InfoLogger.Printf("Fetching daily cost records of resource group %s from %s to %s", cfg.ResourceGroupName, query.From.Format(time.DateOnly), query.To.Format(time.DateOnly))
+	result, err := queryCosts(ctx, cred, cfg, query.From, query.To, to.Ptr(armcostmanagement.GranularityTypeDaily), GroupByResourceID, "MeterCategory")
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, nil
+	}
+	return parseCostRecords(result.Columns, result.Rows)
+}
+
+// parseCostRecords reads daily cost rows by column name
+func parseCostRecords(columns []*armcostmanagement.QueryColumn, rows [][]any) ([]CostRecord, error) {
+	dateIdx := columnIndex(columns, "UsageDate")
+	resourceIdx := columnIndex(columns, GroupByResourceID)
+	meterIdx := columnIndex(columns, "MeterCategory")
+	costIdx := columnIndex(columns, costColumn, "totalCost", "Cost")
+	currencyIdx := columnIndex(columns, "Currency")
+	if dateIdx < 0 || resourceIdx < 0 || meterIdx < 0 || costIdx < 0 {
+		return nil, fmt.Errorf("cost data is missing a date, resource, meter category or cost column, got columns %s", columnNames(columns))
+	}
+
+	var records []CostRecord
+	for n, row := range rows {
+		if len(row) != len(columns) {
+			return nil, fmt.Errorf("cost data row %d has %d values, expected %d", n, len(row), len(columns))
+		}
+		cost, ok := row[costIdx].(float64)
+		if !ok {
+			return nil, fmt.Errorf("cost data row %d has a non numeric cost %v", n, row[costIdx])
+		}
+		date, err := usageDate(row[dateIdx])
+		if err != nil {
+			return nil, fmt.Errorf("cost data row %d: %v", n, err)
+		}
+		record := CostRecord{
+			Date:          date,
+			Resource:      fmt.Sprint(row[resourceIdx]),
+			MeterCategory: fmt.Sprint(row[meterIdx]),
+			Cost:          cost,
+		}
+		if currencyIdx >= 0 {
+			record.Currency = fmt.Sprint(row[currencyIdx])
+		}
+		records = append(records, record)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Date != records[j].Date {
+			return records[i].Date < records[j].Date
+		}
+		return records[i].Resource < records[j].Resource
+	})
+	return records, nil
+}
+
+// usageDate formats a UsageDate value, which Cost Management returns either
+// as a number like 20250131 or as a timestamp string
+func usageDate(value any) (string, error) {
+	switch v := value.(type) {
+	case float64:
+		date, err := time.Parse("20060102", strconv.FormatFloat(v, 'f', 0, 64))
+		if err != nil {
+			return "", fmt.Errorf("invalid usage date %v", v)
+		}
+		return date.Format(time.DateOnly), nil
+	case string:
+		if len(v) >= len(time.DateOnly) {
+			if date, err := time.Parse(time.DateOnly, v[:len(time.DateOnly)]); err == nil {
+				return date.Format(time.DateOnly), nil
+			}
+		}
+		if date, err := time.Parse("20060102", v); err == nil {
+			return date.Format(time.DateOnly), nil
+		}
+	}
+	return "", fmt.Errorf("invalid usage date %v", value)
+}
+
+// WriteCostRecordsCSV writes the records as CSV with a header line
+func WriteCostRecordsCSV(w io.Writer, records []CostRecord) error {
+	out := csv.NewWriter(w)
+	if err := out.Write([]string{"date", "resource", "meterCategory", "cost", "currency"}); err != nil {
+		return err
+	}
+	for _, r := range records {
+		line := []string{r.Date, r.Resource, r.MeterCategory, strconv.FormatFloat(r.Cost, 'f', -1, 64), r.Currency}
+		if err := out.Write(line); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// WriteCostRecordsJSON writes the records as a JSON array
+func WriteCostRecordsJSON(w io.Writer, records []CostRecord) error {
+	if records == nil {
+		records = []CostRecord{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+// PreviousPeriod returns the period to compare with from..until. A period
+// starting on the first of a month is compared with the same days of the
+// previous month, anything else with the same number of days right before it.
+func PreviousPeriod(from, until time.Time) (time.Time, time.Time) {
+	if from.Day() == 1 && from.Year() == until.Year() && from.Month() == until.Month() {
+		prevFrom := from.AddDate(0, -1, 0)
+		prevTo := prevFrom.AddDate(0, 0, until.Day()-1)
+		// The previous month can be shorter, e.g. March 31 compares with February 28
+		if lastDay := from.AddDate(0, 0, -1); prevTo.After(lastDay) {
+			prevTo = lastDay
+		}
+		return prevFrom, prevTo
+	}
+	days := int(until.Sub(from).Hours()/24) + 1
+	return from.AddDate(0, 0, -days), from.AddDate(0, 0, -1)
+}
+
+// CostDelta is the change in cost of one group between two periods
+type CostDelta struct {
+	Group    string  `json:"group"`
+	Currency string  `json:"currency"`
+	Current  float64 `json:"current"`
+	Previous float64 `json:"previous"`
+	Delta    float64 `json:"delta"`
+}
+
+// CostComparison puts a report next to the report of the previous period
+type CostComparison struct {
+	Current  *CostReport `json:"current"`
+	Previous *CostReport `json:"previous"`
+	Deltas   []CostDelta `json:"deltas"`
+}
+
+// CompareCosts matches the groups of two reports, which must use the same
+// grouping, and computes the change per group, largest increase first
+func CompareCosts(current, previous *CostReport) *CostComparison {
+	type key struct{ group, currency string }
+	deltas := map[key]*CostDelta{}
+	var order []key
+	get := func(row CostRow) *CostDelta {
+		k := key{row.Group, row.Currency}
+		if d, ok := deltas[k]; ok {
+			return d
+		}
+		d := &CostDelta{Group: row.Group, Currency: row.Currency}
+		deltas[k] = d
+		order = append(order, k)
+		return d
+	}
+	for _, row := range current.Rows {
+		get(row).Current += row.Cost
+	}
+	for _, row := range previous.Rows {
+		get(row).Previous += row.Cost
+	}
+
+	comparison := &CostComparison{Current: current, Previous: previous}
+	for _, k := range order {
+		d := deltas[k]
+		d.Delta = d.Current - d.Previous
+		comparison.Deltas = append(comparison.Deltas, *d)
+	}
+	sort.SliceStable(comparison.Deltas, func(i, j int) bool {
+		return comparison.Deltas[i].Delta > comparison.Deltas[j].Delta
+	})
+	return comparison
+}
+
+// WriteText writes both periods side by side with the change per group
+func (c *CostComparison) WriteText(w io.Writer) {
+	cur, prev := c.Current, c.Previous
+	fmt.Fprintf(w, "Cost Comparison for Resource Group: %s\n", cur.ResourceGroup)
+	fmt.Fprintln(w, "============================================")
+	fmt.Fprintf(w, "Current period:  %s to %s\n", cur.From.Format(time.DateOnly), cur.To.Format(time.DateOnly))
+	fmt.Fprintf(w, "Previous period: %s to %s\n\n", prev.From.Format(time.DateOnly), prev.To.Format(time.DateOnly))
+
+	if len(c.Deltas) == 0 {
+		fmt.Fprintln(w, "No cost data available for either period.")
+		return
+	}
+
+	fmt.Fprintf(w, "%-40s %10s %10s %10s %8s\n", cur.GroupBy, "Previous", "Current", "Change", "%")
+	fmt.Fprintln(w, "--------------------------------------------------------------------------------")
+	for _, d := range c.Deltas {
+		group := d.Group
+		if cur.GroupBy == GroupByResourceID {
+			group = shortID(group)
+		}
+		fmt.Fprintf(w, "%-40s %10.2f %10.2f %+10.2f %8s %s\n", group, d.Previous, d.Current, d.Delta, percentChange(d.Previous, d.Current), d.Currency)
+	}
+}
+
+// percentChange formats the relative change, or "new" when there was no
+// previous cost
+func percentChange(previous, current float64) string {
+	if previous == 0 {
+		if current == 0 {
+			return ""
+		}
+		return "new"
+	}
+	change := (current - previous) / previous * 100
+	if math.Abs(change) < 0.05 {
+		return "0.0%"
+	}
+	return fmt.Sprintf("%+.1f%%", change)
+}
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// Supported values for AUTH_METHOD
+const (
+	AuthDefault           = "default"
+	AuthClientSecret      = "client-secret"
+	AuthClientCertificate = "client-certificate"
+	AuthManagedIdentity   = "managed-identity"
+	AuthWorkloadIdentity  = "workload-identity"
+	AuthAzureCLI          = "azure-cli"
+	AuthDeviceCode        = "device-code"
+)
+
+var authMethods = []string{
+	AuthDefault,
+	AuthClientSecret,
+	AuthClientCertificate,
+	AuthManagedIdentity,
+	AuthWorkloadIdentity,
+	AuthAzureCLI,
+	AuthDeviceCode,
+}
+
+// NewCredential returns the credential selected by cfg.AuthMethod. An empty
+// method falls back to the default azidentity chain.
+func NewCredential(cfg *Config) (azcore.TokenCredential, error) {
+	method := cfg.AuthMethod
+	if method == "" {
+		method = AuthDefault
+	}
+	InfoLogger.Printf("Creating Azure credentials using %s authentication", method)
+
+	switch method {
+	case AuthDefault:
+		return azidentity.NewDefaultAzureCredential(nil)
+	case AuthClientSecret:
+		return azidentity.NewClientSecretCredential(cfg.TenantID, cfg.ClientID, cfg.ClientSecret, nil)
+	case AuthClientCertificate:
+		data, err := os.ReadFile(cfg.ClientCertificatePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client certificate: %v", err)
+		}
+		var password []byte
+		if cfg.ClientCertificatePassword != "" {
+			password = []byte(cfg.ClientCertificatePassword)
+		}
+		certs, key, err := azidentity.ParseCertificates(data, password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse client certificate: %v", err)
+		}
+		return azidentity.NewClientCertificateCredential(cfg.TenantID, cfg.ClientID, certs, key, nil)
+	case AuthManagedIdentity:
+		opts := &azidentity.ManagedIdentityCredentialOptions{}
+		if cfg.ClientID != "" {
+			opts.ID = azidentity.ClientID(cfg.ClientID)
+		}
+		return azidentity.NewManagedIdentityCredential(opts)
+	case AuthWorkloadIdentity:
+		return azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			TenantID: cfg.TenantID,
+			ClientID: cfg.ClientID,
+		})
+	case AuthAzureCLI:
+		return azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{
+			TenantID: cfg.TenantID,
+		})
+	case AuthDeviceCode:
+		return azidentity.NewDeviceCodeCredential(&azidentity.DeviceCodeCredentialOptions{
+			TenantID: cfg.TenantID,
+			ClientID: cfg.ClientID,
+		})
+	}
+	return nil, fmt.Errorf("unknown AUTH_METHOD %q", cfg.AuthMethod)
+}
+
+// validateAuth checks that the settings needed by the chosen AUTH_METHOD exist
+func (c *Config) validateAuth() []string {
+	var problems []string
+	require := func(key, value string) {
+		if value == "" {
+			problems = append(problems, fmt.Sprintf("%s is required when AUTH_METHOD is %s", key, c.AuthMethod))
+		}
+	}
+
+	switch c.AuthMethod {
+	case "", AuthDefault, AuthManagedIdentity, AuthWorkloadIdentity, AuthAzureCLI, AuthDeviceCode:
+	case AuthClientSecret:
+		require("AZURE_TENANT_ID", c.TenantID)
+		require("AZURE_CLIENT_ID", c.ClientID)
+		require("AZURE_CLIENT_SECRET", c.ClientSecret)
+	case AuthClientCertificate:
+		require("AZURE_TENANT_ID", c.TenantID)
+		require("AZURE_CLIENT_ID", c.ClientID)
+		require("AZURE_CLIENT_CERTIFICATE_PATH", c.ClientCertificatePath)
+	default:
+		problems = append(problems, fmt.Sprintf("AUTH_METHOD %q is not supported, expected one of: %s", c.AuthMethod, strings.Join(authMethods, ", ")))
+	}
+	return problems
+}
@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+// TypeDisk is the resource type of managed disks, such as a VM's OS disk
+const TypeDisk = "Microsoft.Compute/disks"
+
+// ResourceRef identifies a resource in the configured resource group.
+// Parent is set for child resources: the vnet of a subnet or the NSG of a
+// security rule.
+type ResourceRef struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Parent string `json:"parent,omitempty"`
+}
+
+func (r ResourceRef) String() string {
+	if r.Parent != "" {
+		return fmt.Sprintf("%s %s/%s", r.Type, r.Parent, r.Name)
+	}
+	return fmt.Sprintf("%s %s", r.Type, r.Name)
+}
+
+// DeleteResource deletes a single resource and waits for the deletion to
+// finish. A resource that does not exist counts as deleted.
+func DeleteResource(ctx context.Context, cred azcore.TokenCredential, cfg *Config, ref ResourceRef) error {
+	InfoLogger.Printf("Deleting %s", ref)
+	err := deleteResource(ctx, cred, cfg, ref)
+	if isNotFound(err) {
+		InfoLogger.Printf("%s does not exist, nothing to delete", ref)
+		return nil
+	}
+	if err != nil {
+		ErrorLogger.Printf("Failed to delete %s: %v", ref, err)
+		return fmt.Errorf("failed to delete %s: %v", ref, err)
+	}
+	InfoLogger.Printf("Deleted %s", ref)
+	return nil
+}
+
+func deleteResource(ctx context.Context, cred azcore.TokenCredential, cfg *Config, ref ResourceRef) error {
+	rg := cfg.ResourceGroupName
+	opts := &runtime.PollUntilDoneOptions{Frequency: 5 * time.Second}
+
+	switch ref.Type {
+	case TypeVM:
+		client, err := armcompute.NewVirtualMachinesClient(cfg.SubscriptionID, cred, nil)
+		if err != nil {
+			return err
+		}
+		poller, err := client.BeginDelete(ctx, rg, ref.Name, nil)
+		if err != nil {
+			return err
+		}
+		_, err = poller.PollUntilDone(ctx, opts)
+		return err
+	case TypeDisk:
+		client, err := armcompute.NewDisksClient(cfg.SubscriptionID, cred, nil)
+		if err != nil {
+			return err
+		}
+		poller, err := client.BeginDelete(ctx, rg, ref.Name, nil)
+		if err != nil {
+			return err
+		}
+		_, err = poller.PollUntilDone(ctx, opts)
+		return err
+	case TypeNIC:
+		client, err := armnetwork.NewInterfacesClient(cfg.SubscriptionID, cred, nil)
+		if err != nil {
+			return err
+		}
+		poller, err := client.BeginDelete(ctx, rg, ref.Name, nil)
+		if err != nil {
+			return err
+		}
+		_, err = poller.PollUntilDone(ctx, opts)
+		return err
+	case TypeSecurityRule:
+		client, err := armnetwork.NewSecurityRulesClient(cfg.SubscriptionID, cred, nil)
+		if err != nil {
+			return err
+		}
+		poller, err := client.BeginDelete(ctx, rg, ref.Parent, ref.Name, nil)
+		if err != nil {
+			return err
+		}
+		_, err = poller.PollUntilDone(ctx, opts)
+		return err
+	case TypeNSG:
+		client, err := armnetwork.NewSecurityGroupsClient(cfg.SubscriptionID, cred, nil)
+		if err != nil {
+			return err
+		}
+		poller, err := client.BeginDelete(ctx, rg, ref.Name, nil)
+		if err != nil {
+			return err
+		}
+		_, err = poller.PollUntilDone(ctx, opts)
+		return err
+	case TypePublicIP:
+		client, err := armnetwork.NewPublicIPAddressesClient(cfg.SubscriptionID, cred, nil)
+		if err != nil {
+			return err
+		}
+		poller, err := client.BeginDelete(ctx, rg, ref.Name, nil)
+		if err != nil {
+			return err
+		}
+		_, err = poller.PollUntilDone(ctx, opts)
+		return err
+	case TypeSubnet:
+		client, err := armnetwork.NewSubnetsClient(cfg.SubscriptionID, cred, nil)
+		if err != nil {
+			return err
+		}
+		poller, err := client.BeginDelete(ctx, rg, ref.Parent, ref.Name, nil)
+		if err != nil {
+			return err
+		}
+		_, err = poller.PollUntilDone(ctx, opts)
+		return err
+	case TypeVirtualNetwork:
+		client, err := armnetwork.NewVirtualNetworksClient(cfg.SubscriptionID, cred, nil)
+		if err != nil {
+			return err
+		}
+		poller, err := client.BeginDelete(ctx, rg, ref.Name, nil)
+		if err != nil {
+			return err
+		}
+		_, err = poller.PollUntilDone(ctx, opts)
+		return err
+	}
+	return fmt.Errorf("deleting %s resources is not supported", ref.Type)
+}
+
+// isNotFound reports whether err is an Azure 404 response
+func isNotFound(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusNotFound
+}
+
+// DeleteResourceGroup deletes the configured resource group with everything
+// in it and waits for the deletion to finish
+func DeleteResourceGroup(ctx context.Context, cred azcore.TokenCredential, cfg *Config) error {
+	groupsClient, err := armresources.NewResourceGroupsClient(cfg.SubscriptionID, cred, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create resource groups client: %v", err)
+	}
+
+	InfoLogger.Printf("Deleting resource group %q...", cfg.ResourceGroupName)
+	delPoller, err := groupsClient.BeginDelete(ctx, cfg.ResourceGroupName, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin resource group deletion: %v", err)
+	}
+
+	_, err = delPoller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{
+		Frequency: 30 * time.Second,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete resource group deletion: %v", err)
+	}
+	InfoLogger.Printf("Resource group %q deleted", cfg.ResourceGroupName)
+	return nil
+}
+
+// VMResources looks up a VM and returns it together with the resources that
+// only exist for it: its OS disk, NICs and the public IPs on those NICs.
+// They are returned in the order they have to be deleted.
+func VMResources(ctx context.Context, cred azcore.TokenCredential, cfg *Config, vmName string) ([]ResourceRef, error) {
+	vmClient, err := armcompute.NewVirtualMachinesClient(cfg.SubscriptionID, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create VM client: %v", err)
+	}
+	vm, err := vmClient.Get(ctx, cfg.ResourceGroupName, vmName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get VM %s: %v", vmName, err)
+	}
+
+	refs := []ResourceRef{{Type: TypeVM, Name: vmName}}
+	if vm.Properties == nil {
+		return refs, nil
+	}
+
+	if storage := vm.Properties.StorageProfile; storage != nil && storage.OSDisk != nil && storage.OSDisk.Name != nil {
+		refs = append(refs, ResourceRef{Type: TypeDisk, Name: *storage.OSDisk.Name})
+	}
+
+	if vm.Properties.NetworkProfile == nil {
+		return refs, nil
+	}
+	nicClient, err := armnetwork.NewInterfacesClient(cfg.SubscriptionID, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create network interfaces client: %v", err)
+	}
+
+	var publicIPs []ResourceRef
+	for _, nicRef := range vm.Properties.NetworkProfile.NetworkInterfaces {
+		if nicRef.ID == nil {
+			continue
+		}
+		nicID, err := arm.ParseResourceID(*nicRef.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse NIC ID %s: %v", *nicRef.ID, err)
+		}
+		refs = append(refs, ResourceRef{Type: TypeNIC, Name: nicID.Name})
+
+		nic, err := nicClient.Get(ctx, cfg.ResourceGroupName, nicID.Name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get NIC %s: %v", nicID.Name, err)
+		}
+		if nic.Properties == nil {
+			continue
+		}
+		for _, ipConfig := range nic.Properties.IPConfigurations {
+			if ipConfig.Properties == nil || ipConfig.Properties.PublicIPAddress == nil || ipConfig.Properties.PublicIPAddress.ID == nil {
+				continue
+			}
+			publicIPID, err := arm.ParseResourceID(*ipConfig.Properties.PublicIPAddress.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse public IP ID: %v", err)
+			}
+			publicIPs = append(publicIPs, ResourceRef{Type: TypePublicIP, Name: publicIPID.Name})
+		}
+	}
+	// Public IPs can only go once the NICs using them are deleted
+	return append(refs, publicIPs...), nil
+}
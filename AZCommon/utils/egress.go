@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Outbound presets accepted by EGRESS_ALLOW
+const (
+	EgressDNS   = "dns"
+	EgressNTP   = "ntp"
+	EgressHTTP  = "http"
+	EgressHTTPS = "https"
+)
+
+// Values of DEFAULT_DENY
+const (
+	DenyInbound  = "inbound"
+	DenyOutbound = "outbound"
+	DenyBoth     = "both"
+)
+
+// EgressValues lists the presets accepted by EGRESS_ALLOW
+func EgressValues() []string {
+	return []string{EgressDNS, EgressNTP, EgressHTTP, EgressHTTPS}
+}
+
+// DefaultDenyValues lists the values accepted by DEFAULT_DENY
+func DefaultDenyValues() []string {
+	return []string{DenyInbound, DenyOutbound, DenyBoth}
+}
+
+// egressPresets are the outbound rules behind each EGRESS_ALLOW preset. DNS
+// only needs the Internet since Azure DNS at 168.63.129.16 is not filtered by
+// NSGs. HTTP is separate from HTTPS as Ubuntu mirrors are still reached over
+// HTTP.
+var egressPresets = map[string]RuleSpec{
+	EgressDNS:   {Name: "Allow-DNS-Out", Protocol: ProtocolAny, Ports: "53", Destination: "Internet"},
+	EgressNTP:   {Name: "Allow-NTP-Out", Protocol: ProtocolUDP, Ports: "123", Destination: "Internet"},
+	EgressHTTP:  {Name: "Allow-HTTP-Out", Protocol: ProtocolTCP, Ports: "80", Destination: "Internet"},
+	EgressHTTPS: {Name: "Allow-HTTPS-Out", Protocol: ProtocolTCP, Ports: "443", Destination: "Internet"},
+}
+
+// validateEgress checks the outbound policy settings
+func (c *Config) validateEgress() []string {
+	var problems []string
+	for _, preset := range splitList(c.EgressAllow) {
+		if _, ok := egressPresets[preset]; !ok {
+			problems = append(problems, fmt.Sprintf("EGRESS_ALLOW entry %q is not one of %s", preset, strings.Join(EgressValues(), ", ")))
+		}
+	}
+	for _, destination := range splitList(c.EgressVPNDestinations) {
+		if _, err := parseAddress(destination); err != nil {
+			problems = append(problems, fmt.Sprintf("EGRESS_VPN_DESTINATIONS: %v", err))
+		}
+	}
+	if c.DefaultDeny != "" {
+		if value, _ := lookupFold(DefaultDenyValues(), c.DefaultDeny); value != c.DefaultDeny {
+			problems = append(problems, fmt.Sprintf("DEFAULT_DENY %q is not one of %s", c.DefaultDeny, strings.Join(DefaultDenyValues(), ", ")))
+		}
+	}
+	if len(problems) == 0 && len(ValidateRuleSpecs(c.Rules)) == 0 {
+		problems = append(problems, c.validateBootstrapEgress()...)
+	}
+	return problems
+}
+
+// bootstrapPresets are the outbound presets cloud-init needs to install the
+// VPN server with apt on first boot. The Ubuntu mirrors on Azure are reached
+// over HTTP, DNS is left out as Azure DNS is not filtered.
+var bootstrapPresets = []string{EgressHTTP}
+
+// validateBootstrapEgress checks that a deny-all outbound rule still lets
+// apt through, either with an EGRESS_ALLOW preset or an outbound rule
+// covering it. Otherwise the server never gets its packages and cloud-init
+// fails without anything showing up in the deployment.
+func (c *Config) validateBootstrapEgress() []string {
+	if c.DefaultDeny != DenyOutbound && c.DefaultDeny != DenyBoth {
+		return nil
+	}
+	var allowed []resolvedRule
+	for _, rule := range resolveRuleSpecs(append(c.Rules[:len(c.Rules):len(c.Rules)], c.egressRuleSpecs()...)) {
+		if rule.Direction == DirectionOutbound && rule.Access == AccessAllow {
+			allowed = append(allowed, rule)
+		}
+	}
+
+	var problems []string
+	for _, name := range bootstrapPresets {
+		preset := egressPresets[name]
+		preset.Direction = DirectionOutbound
+		needed := resolveRuleSpecs([]RuleSpec{preset})[0]
+		covered := false
+		for _, rule := range allowed {
+			covered = covered || rule.overlaps(needed)
+		}
+		if !covered {
+			problems = append(problems, fmt.Sprintf("DEFAULT_DENY %s blocks outbound port %s, which apt needs to install the VPN server on first boot, add %s to EGRESS_ALLOW", c.DefaultDeny, preset.Ports, name))
+		}
+	}
+	return problems
+}
+
+// egressRuleSpecs builds the outbound allow rules of EGRESS_VPN_DESTINATIONS
+// and EGRESS_ALLOW. A preset already covered by a VPN destination, such as
+// HTTPS when VPN clients may reach the whole Internet, is left out.
+func (c *Config) egressRuleSpecs() []RuleSpec {
+	var specs []RuleSpec
+	for i, destination := range splitList(c.EgressVPNDestinations) {
+		specs = append(specs, RuleSpec{
+			Name:        fmt.Sprintf("Allow-VPN-Out-%d", i+1),
+			Direction:   DirectionOutbound,
+			Protocol:    ProtocolAny,
+			Destination: destination,
+		})
+	}
+	vpnRules := resolveRuleSpecs(specs)
+
+	for _, name := range splitList(c.EgressAllow) {
+		preset, ok := egressPresets[name]
+		if !ok {
+			continue
+		}
+		preset.Direction = DirectionOutbound
+		resolved := resolveRuleSpecs([]RuleSpec{preset})[0]
+		covered := false
+		for _, vpn := range vpnRules {
+			covered = covered || vpn.overlaps(resolved)
+		}
+		if !covered {
+			specs = append(specs, preset)
+		}
+	}
+	return specs
+}
+
+// denyAllRuleSpecs builds the lowest priority deny-all rules of DEFAULT_DENY,
+// which take over from the Azure defaults that allow VNet and load balancer
+// traffic in and everything out
+func (c *Config) denyAllRuleSpecs() []RuleSpec {
+	var specs []RuleSpec
+	if c.DefaultDeny == DenyInbound || c.DefaultDeny == DenyBoth {
+		specs = append(specs, RuleSpec{Name: "Deny-All-Inbound", Direction: DirectionInbound, Protocol: ProtocolAny, Access: AccessDeny, Priority: MaxRulePriority})
+	}
+	if c.DefaultDeny == DenyOutbound || c.DefaultDeny == DenyBoth {
+		specs = append(specs, RuleSpec{Name: "Deny-All-Outbound", Direction: DirectionOutbound, Protocol: ProtocolAny, Access: AccessDeny, Priority: MaxRulePriority})
+	}
+	return specs
+}
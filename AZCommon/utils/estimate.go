@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+// HoursPerMonth is the number of hours Azure uses to price a month
+const HoursPerMonth = 730
+
+// DefaultEgressGB is the monthly outbound traffic assumed by estimates
+const DefaultEgressGB = 100
+
+// defaultOSDiskGB is the OS disk size of the marketplace Linux images used
+// when the VM does not set one
+const defaultOSDiskGB = 30
+
+// EstimateItem is the estimated monthly cost of one component
+type EstimateItem struct {
+	Component   string  `json:"component"`
+	Description string  `json:"description"`
+	Quantity    float64 `json:"quantity"`
+	Unit        string  `json:"unit"`
+	UnitPrice   float64 `json:"unitPrice"`
+	MonthlyCost float64 `json:"monthlyCost"`
+}
+
+// CostEstimate is the estimated monthly cost of a deployment
+type CostEstimate struct {
+	Currency     string         `json:"currency"`
+	Items        []EstimateItem `json:"items"`
+	MonthlyTotal float64        `json:"monthlyTotal"`
+	// Warnings lists components that could not be priced and are missing
+	// from the total
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// EstimateCost prices the VM, OS disk and public IPs the deployment would
+// create, plus egressGB of outbound traffic, using the same request bodies
+// as the Create functions
+func EstimateCost(ctx context.Context, source PriceSource, cfg *Config, egressGB float64) (*CostEstimate, error) {
+	estimate := &CostEstimate{}
+	vm := VMParams(cfg, "", "")
+
+	// Compute, Linux pay as you go
+	size := string(*vm.Properties.HardwareProfile.VMSize)
+	items, err := source.Prices(ctx, PriceQuery{ServiceName: "Virtual Machines", ArmRegionName: cfg.Location, ArmSkuName: size})
+	if err != nil {
+		return nil, err
+	}
+	items = filterPrices(items, func(item PriceItem) bool {
+		return !strings.Contains(item.ProductName, "Windows") &&
+			!strings.Contains(item.SkuName, "Spot") &&
+			!strings.Contains(item.SkuName, "Low Priority") &&
+			item.UnitOfMeasure == "1 Hour"
+	})
+	estimate.add("Compute", size+" (Linux)", HoursPerMonth, "hours", items)
+
+	// OS disk
+	osDisk := vm.Properties.StorageProfile.OSDisk
+	diskGB := int32(defaultOSDiskGB)
+	if osDisk.DiskSizeGB != nil {
+		diskGB = *osDisk.DiskSizeGB
+	}
+	product, tier := diskTier(string(*osDisk.ManagedDisk.StorageAccountType), diskGB)
+	if product == "" {
+		estimate.Warnings = append(estimate.Warnings, fmt.Sprintf("OS disk: no pricing known for %s disks", *osDisk.ManagedDisk.StorageAccountType))
+	} else {
+		sku := tier + " LRS"
+		items, err = source.Prices(ctx, PriceQuery{ServiceName: "Storage", ArmRegionName: cfg.Location, ProductName: product, SkuName: sku})
+		if err != nil {
+			return nil, err
+		}
+		items = filterPrices(items, func(item PriceItem) bool {
+			return item.MeterName == sku+" Disk" && item.UnitOfMeasure == "1/Month"
+		})
+		estimate.add("OS disk", fmt.Sprintf("%s %s (%d GiB)", product, tier, diskGB), 1, "month", items)
+	}
+
+	// Public IPs, the IPv6 one only for dual stack
+	publicIPs := []armnetwork.PublicIPAddress{PublicIPParams(cfg)}
+	if cfg.DualStack() {
+		publicIPs = append(publicIPs, PublicIPv6Params(cfg))
+	}
+	for _, publicIP := range publicIPs {
+		ipSKU := string(*publicIP.SKU.Name)
+		allocation := string(*publicIP.Properties.PublicIPAllocationMethod)
+		version := string(armnetwork.IPVersionIPv4)
+		if publicIP.Properties.PublicIPAddressVersion != nil {
+			version = string(*publicIP.Properties.PublicIPAddressVersion)
+		}
+		meter := fmt.Sprintf("%s %s %s Public IP", ipSKU, version, allocation)
+		items, err = source.Prices(ctx, PriceQuery{ServiceName: "Virtual Network", ArmRegionName: cfg.Location, ProductName: "IP Addresses", SkuName: ipSKU})
+		if err != nil {
+			return nil, err
+		}
+		items = filterPrices(items, func(item PriceItem) bool {
+			return item.MeterName == meter && item.UnitOfMeasure == "1 Hour"
+		})
+		estimate.add("Public IP", meter, HoursPerMonth, "hours", items)
+	}
+
+	// Egress over the Microsoft network, priced in tiers
+	items, err = source.Prices(ctx, PriceQuery{ServiceName: "Bandwidth", ArmRegionName: cfg.Location, MeterName: "Standard Data Transfer Out"})
+	if err != nil {
+		return nil, err
+	}
+	if preferred := filterPrices(items, func(item PriceItem) bool { return strings.Contains(item.ProductName, "MGN") }); len(preferred) > 0 {
+		items = preferred
+	}
+	estimate.add("Egress", "Data transfer out to the internet (estimated)", egressGB, "GB", items)
+
+	return estimate, nil
+}
+
+// add prices quantity units of a component from its price tiers, or records
+// a warning when no price was found
+func (e *CostEstimate) add(component, description string, quantity float64, unit string, tiers []PriceItem) {
+	if len(tiers) == 0 {
+		e.Warnings = append(e.Warnings, fmt.Sprintf("%s: no price found for %s", component, description))
+		return
+	}
+	if e.Currency == "" {
+		e.Currency = tiers[0].CurrencyCode
+	}
+
+	cost := tieredCost(tiers, quantity)
+	unitPrice := tiers[0].RetailPrice
+	if quantity > 0 {
+		unitPrice = cost / quantity
+	}
+	e.Items = append(e.Items, EstimateItem{
+		Component:   component,
+		Description: description,
+		Quantity:    quantity,
+		Unit:        unit,
+		UnitPrice:   unitPrice,
+		MonthlyCost: cost,
+	})
+	e.MonthlyTotal += cost
+}
+
+// tieredCost prices quantity units, each tier's price applying from its
+// minimum units up to the next tier's
+func tieredCost(tiers []PriceItem, quantity float64) float64 {
+	sorted := append([]PriceItem(nil), tiers...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].TierMinimumUnits < sorted[j].TierMinimumUnits
+	})
+
+	var cost float64
+	for i, tier := range sorted {
+		if quantity <= tier.TierMinimumUnits {
+			break
+		}
+		upper := quantity
+		if i+1 < len(sorted) && sorted[i+1].TierMinimumUnits < upper {
+			upper = sorted[i+1].TierMinimumUnits
+		}
+		cost += (upper - tier.TierMinimumUnits) * tier.RetailPrice
+	}
+	return cost
+}
+
+// diskTier returns the product and size tier, such as "S4", a managed disk
+// of sizeGB is billed as
+func diskTier(storageAccountType string, sizeGB int32) (product, tier string) {
+	var prefix string
+	switch storageAccountType {
+	case "Standard_LRS":
+		product, prefix = "Standard HDD Managed Disks", "S"
+	case "StandardSSD_LRS":
+		product, prefix = "Standard SSD Managed Disks", "E"
+	case "Premium_LRS":
+		product, prefix = "Premium SSD Managed Disks", "P"
+	default:
+		return "", ""
+	}
+
+	tiers := []struct {
+		number int
+		sizeGB int32
+	}{{4, 32}, {6, 64}, {10, 128}, {15, 256}, {20, 512}, {30, 1024}, {40, 2048}, {50, 4096}, {60, 8192}, {70, 16384}, {80, 32767}}
+	for _, t := range tiers {
+		if sizeGB <= t.sizeGB {
+			return product, fmt.Sprintf("%s%d", prefix, t.number)
+		}
+	}
+	return product, fmt.Sprintf("%s%d", prefix, tiers[len(tiers)-1].number)
+}
+
+func filterPrices(items []PriceItem, keep func(PriceItem) bool) []PriceItem {
+	var kept []PriceItem
+	for _, item := range items {
+		if keep(item) {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// WriteText writes the estimate as an itemized table
+func (e *CostEstimate) WriteText(w io.Writer) {
+	if e.Currency == "" {
+		fmt.Fprintln(w, "Estimated monthly cost:")
+	} else {
+		fmt.Fprintf(w, "Estimated monthly cost (%s):\n", e.Currency)
+	}
+	for _, item := range e.Items {
+		fmt.Fprintf(w, "    %-10s %-48s %8.0f %-5s x %8.4f = %8.2f\n",
+			item.Component, item.Description, item.Quantity, item.Unit, item.UnitPrice, item.MonthlyCost)
+	}
+	fmt.Fprintf(w, "    %-10s %-48s %28s %8.2f\n", "Total", "", "", e.MonthlyTotal)
+	for _, warning := range e.Warnings {
+		fmt.Fprintf(w, "    warning: %s\n", warning)
+	}
+}
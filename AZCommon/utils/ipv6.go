@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+// Every IPv4 and every IPv6 address, as written in the rules
+const (
+	anyIPv4 = "0.0.0.0/0"
+	anyIPv6 = "::/0"
+)
+
+// ipv6SubnetBits is the only IPv6 subnet size Azure accepts
+const ipv6SubnetBits = 64
+
+// DualStack reports whether IPv6 is enabled, which ADDRESS_PREFIX_V6 opts in to
+func (c *Config) DualStack() bool {
+	return c.AddressPrefixV6 != ""
+}
+
+// PublicIPv6Name returns PUBLIC_IP_V6_NAME, or the IPv4 public IP name with a
+// "-v6" suffix
+func (c *Config) PublicIPv6Name() string {
+	return orDefault(c.PublicIPV6Name, c.PublicIPName+"-v6")
+}
+
+// validateIPv6 checks the dual stack settings
+func (c *Config) validateIPv6() []string {
+	if !c.DualStack() {
+		if c.SubnetPrefixV6 != "" {
+			return []string{"SUBNET_PREFIX_V6 is set without ADDRESS_PREFIX_V6"}
+		}
+		return nil
+	}
+
+	var problems []string
+	addressPrefix, addressErr := parsePrefix("ADDRESS_PREFIX_V6", c.AddressPrefixV6)
+	if addressErr != "" {
+		problems = append(problems, addressErr)
+	} else if !addressPrefix.Addr().Is6() {
+		problems = append(problems, fmt.Sprintf("ADDRESS_PREFIX_V6 %s is not an IPv6 CIDR", addressPrefix))
+	}
+
+	if c.SubnetPrefixV6 == "" {
+		return append(problems, "SUBNET_PREFIX_V6 is required with ADDRESS_PREFIX_V6")
+	}
+	subnetPrefix, subnetErr := parsePrefix("SUBNET_PREFIX_V6", c.SubnetPrefixV6)
+	switch {
+	case subnetErr != "":
+		problems = append(problems, subnetErr)
+	case !subnetPrefix.Addr().Is6() || subnetPrefix.Bits() != ipv6SubnetBits:
+		problems = append(problems, fmt.Sprintf("SUBNET_PREFIX_V6 %s is not an IPv6 /%d, the only size Azure accepts", subnetPrefix, ipv6SubnetBits))
+	case addressPrefix.IsValid() && !prefixContains(addressPrefix, subnetPrefix):
+		problems = append(problems, fmt.Sprintf("SUBNET_PREFIX_V6 %s is not inside ADDRESS_PREFIX_V6 %s", subnetPrefix, addressPrefix))
+	}
+	return problems
+}
+
+// PublicIPv6Params builds the static Standard IPv6 public IP request body
+// sent by CreatePublicIPv6
+func PublicIPv6Params(cfg *Config) armnetwork.PublicIPAddress {
+	publicIP := PublicIPParams(cfg)
+	publicIP.Properties.PublicIPAddressVersion = to.Ptr(armnetwork.IPVersionIPv6)
+	return publicIP
+}
+
+// CreatePublicIPv6 creates the IPv6 public IP of a dual stack deployment
+func CreatePublicIPv6(
+	ctx context.Context,
+	cred azcore.TokenCredential,
+	cfg *Config,
+) (*runtime.Poller[armnetwork.PublicIPAddressesClientCreateOrUpdateResponse], error) {
+	publicIPName := cfg.PublicIPv6Name()
+	InfoLogger.Printf("Creating IPv6 public IP address %s in %s", publicIPName, cfg.Location)
+
+	publicIPClient, err := armnetwork.NewPublicIPAddressesClient(cfg.SubscriptionID, cred, nil)
+	if err != nil {
+		ErrorLogger.Printf("Failed to create public IP client: %v", err)
+		return nil, fmt.Errorf("failed to create public IP client: %v", err)
+	}
+
+	publicIPPoller, err := publicIPClient.BeginCreateOrUpdate(ctx, cfg.ResourceGroupName, publicIPName, PublicIPv6Params(cfg), nil)
+	if err != nil {
+		ErrorLogger.Printf("Failed to begin IPv6 public IP creation: %v", err)
+		return nil, fmt.Errorf("failed to begin IPv6 public IP creation: %v", err)
+	}
+	return publicIPPoller, nil
+}
+
+// GetPublicIPv6Address returns the address assigned to the IPv6 public IP
+func GetPublicIPv6Address(ctx context.Context, cred azcore.TokenCredential, cfg *Config) (string, error) {
+	return publicIPAddress(ctx, cred, cfg, cfg.PublicIPv6Name())
+}
+
+// mirrorIPv6 returns an IPv6 twin for every rule open to all IPv4 addresses,
+// "0.0.0.0/0" only matching IPv4 traffic. The twin is named "<name>-IPv6"
+// and opens the same ports to "::/0". A rule limited to specific IPv4
+// ranges on the other side has no IPv6 equivalent and is left alone, as are
+// "*" and service tags which cover both already.
+func mirrorIPv6(specs []RuleSpec) []RuleSpec {
+	var mirrors []RuleSpec
+	for _, spec := range specs {
+		openSources := slices.Contains(spec.Sources, anyIPv4)
+		openDestination := spec.Destination == anyIPv4
+		if !openSources && !openDestination {
+			continue
+		}
+
+		mirror := spec
+		mirror.Name = spec.Name + "-IPv6"
+		mirror.Priority = 0
+		if openSources {
+			mirror.Sources = []string{anyIPv6}
+		} else if hasIPv4(spec.Sources) {
+			continue
+		}
+		if openDestination {
+			mirror.Destination = anyIPv6
+		} else if hasIPv4([]string{spec.Destination}) {
+			continue
+		}
+		mirrors = append(mirrors, mirror)
+	}
+	return mirrors
+}
+
+// hasIPv4 reports whether any of the values is an IPv4 address or CIDR
+func hasIPv4(values []string) bool {
+	for _, value := range values {
+		if prefix, err := netip.ParsePrefix(value); err == nil && prefix.Addr().Is4() {
+			return true
+		}
+		if addr, err := netip.ParseAddr(value); err == nil && addr.Is4() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+// DefaultManagementPorts are the ports restricted to MANAGEMENT_SOURCES when
+// MANAGEMENT_PORTS is not set
+const DefaultManagementPorts = "22"
+
+// MyIPSource stands for the caller's public IP in MANAGEMENT_SOURCES
+const MyIPSource = "myip"
+
+// DefaultIPResolverURL answers with the caller's public IP as plain text
+const DefaultIPResolverURL = "https://api.ipify.org"
+
+// IPResolver finds the public IP address the caller reaches Azure from
+type IPResolver interface {
+	PublicIP(ctx context.Context) (netip.Addr, error)
+}
+
+// HTTPIPResolver asks a what-is-my-IP service that answers a GET with the
+// address as plain text, such as api.ipify.org or ifconfig.me/ip
+type HTTPIPResolver struct {
+	URL    string
+	Client *http.Client
+}
+
+// PublicIP queries the service
+func (r *HTTPIPResolver) PublicIP(ctx context.Context) (netip.Addr, error) {
+	client := r.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.URL, nil)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("failed to look up public IP at %s: %v", r.URL, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("failed to read public IP from %s: %v", r.URL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return netip.Addr{}, fmt.Errorf("public IP lookup at %s returned %s", r.URL, resp.Status)
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(string(body)))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("public IP lookup at %s returned %q, not an IP address", r.URL, strings.TrimSpace(string(body)))
+	}
+	return addr.Unmap(), nil
+}
+
+// IPResolver returns the resolver configured by IP_RESOLVER_URL
+func (c *Config) IPResolver() IPResolver {
+	return &HTTPIPResolver{URL: orDefault(c.IPResolverURL, DefaultIPResolverURL)}
+}
+
+// managementPorts returns MANAGEMENT_PORTS or the default
+func (c *Config) managementPorts() string {
+	return orDefault(c.ManagementPorts, DefaultManagementPorts)
+}
+
+// validateManagement checks the management access settings
+func (c *Config) validateManagement() []string {
+	var problems []string
+	for _, source := range splitList(c.ManagementSources) {
+		if strings.EqualFold(source, MyIPSource) {
+			continue
+		}
+		a, err := parseAddress(source)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("MANAGEMENT_SOURCES: %v", err))
+		case !a.isPrefix():
+			problems = append(problems, fmt.Sprintf("MANAGEMENT_SOURCES entry %q is not an IP address or CIDR", source))
+		}
+	}
+	if c.ManagementPorts != "" {
+		if _, err := parsePorts(c.ManagementPorts); err != nil {
+			problems = append(problems, fmt.Sprintf("MANAGEMENT_PORTS: %v", err))
+		}
+	}
+	if c.IPResolverURL != "" {
+		if u, err := url.Parse(c.IPResolverURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("IP_RESOLVER_URL %q is not an http(s) URL", c.IPResolverURL))
+		}
+	}
+	return problems
+}
+
+// ResolveSources replaces "myip" among sources with the caller's public IP
+// as a single address CIDR. The resolver is only asked when needed.
+func ResolveSources(ctx context.Context, sources []string, resolver IPResolver) ([]string, error) {
+	var resolved []string
+	for _, source := range sources {
+		if !strings.EqualFold(source, MyIPSource) {
+			resolved = append(resolved, source)
+			continue
+		}
+		addr, err := resolver.PublicIP(ctx)
+		if err != nil {
+			return nil, err
+		}
+		InfoLogger.Printf("Resolved %s to %s", MyIPSource, addr)
+		resolved = append(resolved, netip.PrefixFrom(addr, addr.BitLen()).String())
+	}
+	return resolved, nil
+}
+
+// ResolveManagementSources resolves "myip" in MANAGEMENT_SOURCES in place.
+// It must run before the rules are built when MANAGEMENT_SOURCES uses it.
+func (c *Config) ResolveManagementSources(ctx context.Context, resolver IPResolver) error {
+	sources, err := ResolveSources(ctx, splitList(c.ManagementSources), resolver)
+	if err != nil {
+		return err
+	}
+	c.ManagementSources = strings.Join(sources, ",")
+	return nil
+}
+
+// unresolvedSources reports whether MANAGEMENT_SOURCES still holds "myip"
+func (c *Config) unresolvedSources() bool {
+	for _, source := range splitList(c.ManagementSources) {
+		if strings.EqualFold(source, MyIPSource) {
+			return true
+		}
+	}
+	return false
+}
+
+// LockDown restricts every inbound Allow rule reaching one of ports to
+// sources, except the VPN rule which has to stay reachable from anywhere
+func LockDown(specs []RuleSpec, profile Profile, ports string, sources []string) []RuleSpec {
+	managed, _ := parsePorts(ports)
+	locked := make([]RuleSpec, len(specs))
+	for i, spec := range specs {
+		locked[i] = spec
+		if !isManagementRule(spec, profile, managed) {
+			continue
+		}
+		locked[i].Sources = append([]string(nil), sources...)
+	}
+	return locked
+}
+
+// isManagementRule reports whether a rule opens one of the management ports
+// to inbound traffic
+func isManagementRule(spec RuleSpec, profile Profile, managed []portRange) bool {
+	if strings.EqualFold(spec.Name, profile.RuleName) {
+		return false
+	}
+	direction := orDefault(spec.Direction, DirectionInbound)
+	access := orDefault(spec.Access, AccessAllow)
+	if !strings.EqualFold(direction, DirectionInbound) || !strings.EqualFold(access, AccessAllow) {
+		return false
+	}
+	ports, err := parsePorts(orDefault(spec.Ports, anyPort))
+	return err == nil && portsOverlap(ports, managed)
+}
+
+// LockDownNetSecRules restricts the management rules live in the NSG to
+// MANAGEMENT_SOURCES, the same way deploy does, and returns the rules it
+// changed. The sources must be resolved already. With dryRun nothing is sent
+// to Azure.
+func LockDownNetSecRules(ctx context.Context, cred azcore.TokenCredential, cfg *Config, profile Profile, dryRun bool) ([]armnetwork.SecurityRule, error) {
+	sources := splitList(cfg.ManagementSources)
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no MANAGEMENT_SOURCES to lock down to")
+	}
+	if cfg.unresolvedSources() {
+		return nil, fmt.Errorf("MANAGEMENT_SOURCES uses %s, which has to be resolved first", MyIPSource)
+	}
+	managed, err := parsePorts(cfg.managementPorts())
+	if err != nil {
+		return nil, err
+	}
+	live, err := ListNetSecRules(ctx, cred, cfg)
+	if err != nil {
+		return nil, err
+	}
+	securityRulesClient, err := armnetwork.NewSecurityRulesClient(cfg.SubscriptionID, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create security rules client: %v", err)
+	}
+
+	var changed []armnetwork.SecurityRule
+	for _, rule := range live {
+		if rule == nil || rule.Name == nil || rule.Properties == nil {
+			continue
+		}
+		props := rule.Properties
+		spec := RuleSpec{
+			Name:      *rule.Name,
+			Direction: stringValue(props.Direction),
+			Access:    stringValue(props.Access),
+			Ports:     rulePorts(props),
+		}
+		if !isManagementRule(spec, profile, managed) {
+			continue
+		}
+
+		updated := *rule
+		updatedProps := *props
+		updated.Properties = &updatedProps
+		updatedProps.SourceAddressPrefix, updatedProps.SourceAddressPrefixes = nil, nil
+		if len(sources) == 1 {
+			updatedProps.SourceAddressPrefix = to.Ptr(sources[0])
+		} else {
+			updatedProps.SourceAddressPrefixes = to.SliceOfPtrs(sources...)
+		}
+		if SameRule(*rule, updated) {
+			InfoLogger.Printf("Rule %s is already restricted to %s", *rule.Name, strings.Join(sources, ","))
+			continue
+		}
+		changed = append(changed, updated)
+		if dryRun {
+			continue
+		}
+
+		InfoLogger.Printf("Restricting rule %s to %s", *rule.Name, strings.Join(sources, ","))
+		if _, err := putRule(ctx, securityRulesClient, cfg, updated); err != nil {
+			return changed, err
+		}
+	}
+	return changed, nil
+}
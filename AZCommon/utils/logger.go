@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -14,8 +15,8 @@ var (
 	logFile     *os.File
 )
 
-// InitLogger initializes the logging system
-func InitLogger() error {
+// InitLogger initializes the logging system, log files are named after prefix
+func InitLogger(prefix string) error {
 	// Create logs directory if it doesn't exist
 	logDir := "logs"
 	if err := os.MkdirAll(logDir, 0755); err != nil {
@@ -24,7 +25,7 @@ func InitLogger() error {
 
 	// Create log file with timestamp
 	timestamp := time.Now().Format("2006-01-02_15-04-05")
-	logPath := filepath.Join(logDir, fmt.Sprintf("azure_ovpn_%s.log", timestamp))
+	logPath := filepath.Join(logDir, fmt.Sprintf("%s_%s.log", prefix, timestamp))
 
 	file, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
 	if err != nil {
@@ -45,6 +46,14 @@ func InitLogger() error {
 	return nil
 }
 
+// InitQuietLogger sets up logging for commands whose output is read by other
+// programs, such as completion scripts: info messages are dropped and errors
+// go to stderr
+func InitQuietLogger() {
+	InfoLogger = log.New(io.Discard, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
+	ErrorLogger = log.New(os.Stderr, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
+}
+
 // CloseLogger closes the log file
 func CloseLogger() {
 	if logFile != nil {
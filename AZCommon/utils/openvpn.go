@@ -0,0 +1,281 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OpenVPNDir is where the OpenVPN PKI is kept, one directory per resource
+// group
+const OpenVPNDir = "openvpn"
+
+// openVPNServerDir holds the server's config and keys on the VM, the working
+// directory of openvpn-server@server
+const openVPNServerDir = "/etc/openvpn/server"
+
+// openVPNInterface is the tunnel interface on the server
+const openVPNInterface = "tun0"
+
+// openVPNServerName is the common name of the server certificate, which
+// clients verify
+const openVPNServerName = "server"
+
+// openVPNCiphers are the data channel ciphers offered, AEAD only
+const openVPNCiphers = "AES-256-GCM:AES-128-GCM:CHACHA20-POLY1305"
+
+// OpenVPNPKI is the deployment's CA, server certificate and tls-crypt key,
+// generated locally on first use. The CA key stays local, it signs client
+// certificates and the revocation list.
+type OpenVPNPKI struct {
+	ca          *certificateAuthority
+	CACert      []byte
+	ServerCert  []byte
+	ServerKey   []byte
+	TLSCryptKey []byte
+}
+
+// openVPNPath returns a file in the OpenVPN directory of the deployment
+func openVPNPath(cfg *Config, name string) string {
+	return filepath.Join(OpenVPNDir, cfg.ResourceGroupName, name)
+}
+
+// openVPNPKIFiles are the files of the PKI, in the order they are written
+var openVPNPKIFiles = []string{"ca.key", "ca.crt", "server.key", "server.crt", "tls-crypt.key"}
+
+// LoadOpenVPNPKI reads the PKI of the deployment, creating it the first
+// time with PKI_KEY_TYPE keys and the PKI_CA_DAYS and PKI_CERT_DAYS
+// validity. The files are only readable by the owner.
+func LoadOpenVPNPKI(cfg *Config) (*OpenVPNPKI, error) {
+	pki, err := readOpenVPNPKI(cfg)
+	if err != nil || pki != nil {
+		return pki, err
+	}
+	return newOpenVPNPKI(cfg)
+}
+
+// planOpenVPNPKI reads the PKI for a plan, with placeholders instead of
+// generating one when there is none yet. The placeholder has no CA, so
+// nothing can be signed with it.
+func planOpenVPNPKI(cfg *Config) (*OpenVPNPKI, error) {
+	pki, err := readOpenVPNPKI(cfg)
+	if err != nil || pki != nil {
+		return pki, err
+	}
+	InfoLogger.Printf("No OpenVPN PKI in %s yet, the plan uses placeholders for the keys and certificates deploy generates", filepath.Dir(openVPNPath(cfg, "ca.crt")))
+	placeholder := []byte(plannedSecret + "\n")
+	return &OpenVPNPKI{CACert: placeholder, ServerCert: placeholder, ServerKey: placeholder, TLSCryptKey: placeholder}, nil
+}
+
+// readOpenVPNPKI reads a saved PKI, nil when there is none yet
+func readOpenVPNPKI(cfg *Config) (*OpenVPNPKI, error) {
+	exists, err := fileExists(openVPNPath(cfg, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenVPN CA: %v", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	pki := &OpenVPNPKI{ca: &certificateAuthority{}}
+	if pki.ca.Cert, err = readCertPEM(openVPNPath(cfg, "ca.crt")); err != nil {
+		return nil, fmt.Errorf("failed to read OpenVPN CA: %v", err)
+	}
+	if pki.ca.Key, err = readKeyPEM(openVPNPath(cfg, "ca.key")); err != nil {
+		return nil, fmt.Errorf("failed to read OpenVPN CA key: %v", err)
+	}
+	contents := map[string]*[]byte{
+		"ca.crt":        &pki.CACert,
+		"server.crt":    &pki.ServerCert,
+		"server.key":    &pki.ServerKey,
+		"tls-crypt.key": &pki.TLSCryptKey,
+	}
+	for name, content := range contents {
+		if *content, err = os.ReadFile(openVPNPath(cfg, name)); err != nil {
+			return nil, fmt.Errorf("failed to read OpenVPN %s: %v", name, err)
+		}
+	}
+	return pki, nil
+}
+
+func newOpenVPNPKI(cfg *Config) (*OpenVPNPKI, error) {
+	keyType := cfg.KeyType()
+	ca, err := newCertificateAuthority(cfg.ResourceGroupName+" OpenVPN CA", keyType, cfg.CADays())
+	if err != nil {
+		return nil, err
+	}
+	serverCert, serverKey, err := ca.issue(openVPNServerName, keyType, cfg.CertDays(), x509.ExtKeyUsageServerAuth)
+	if err != nil {
+		return nil, err
+	}
+	tlsCrypt, err := openVPNStaticKey()
+	if err != nil {
+		return nil, err
+	}
+	pki := &OpenVPNPKI{
+		ca:          ca,
+		CACert:      certPEM(ca.Cert),
+		ServerCert:  certPEM(serverCert),
+		TLSCryptKey: tlsCrypt,
+	}
+	if pki.ServerKey, err = keyPEM(serverKey); err != nil {
+		return nil, err
+	}
+	caKey, err := keyPEM(ca.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	contents := map[string][]byte{
+		"ca.key":        caKey,
+		"ca.crt":        pki.CACert,
+		"server.key":    pki.ServerKey,
+		"server.crt":    pki.ServerCert,
+		"tls-crypt.key": pki.TLSCryptKey,
+	}
+	// ca.crt marks a complete PKI, so it is written after its key
+	for _, name := range openVPNPKIFiles {
+		if err := writeSecretFile(openVPNPath(cfg, name), contents[name]); err != nil {
+			return nil, err
+		}
+	}
+	InfoLogger.Printf("Generated OpenVPN %s PKI valid until %s, saved to %s", keyType, ca.Cert.NotAfter.Format("2006-01-02"), filepath.Dir(openVPNPath(cfg, "ca.crt")))
+	return pki, nil
+}
+
+// openVPNStaticKey creates a 2048 bit key in the format of
+// "openvpn --genkey secret", used for tls-crypt
+func openVPNStaticKey() ([]byte, error) {
+	key := make([]byte, 256)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate tls-crypt key: %v", err)
+	}
+	var b strings.Builder
+	b.WriteString("#\n# 2048 bit OpenVPN static key\n#\n-----BEGIN OpenVPN Static key V1-----\n")
+	for i := 0; i < len(key); i += 16 {
+		fmt.Fprintln(&b, hex.EncodeToString(key[i:i+16]))
+	}
+	b.WriteString("-----END OpenVPN Static key V1-----\n")
+	return []byte(b.String()), nil
+}
+
+// OpenVPNServerConf renders the server's server.conf. Clients get addresses
+// from TUNNEL_CIDR and send all their traffic through the tunnel unless
+// their profile ignores the pushed route.
+func OpenVPNServerConf(cfg *Config, profile Profile) string {
+	tunnel := cfg.TunnelPrefix()
+	protocol := strings.ToLower(profile.VPNProtocol)
+	if cfg.DualStack() {
+		// Listens on IPv4 as well
+		protocol += "6"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "port %d\n", cfg.VPNPort(profile))
+	fmt.Fprintf(&b, "proto %s\n", protocol)
+	fmt.Fprintf(&b, "dev %s\n", openVPNInterface)
+	fmt.Fprintln(&b, "topology subnet")
+	fmt.Fprintf(&b, "server %s %s\n", tunnel.Addr(), net.IP(net.CIDRMask(tunnel.Bits(), 32)))
+	for _, file := range []string{"ca ca.crt", "cert server.crt", "key server.key", "tls-crypt tls-crypt.key", "crl-verify crl.pem"} {
+		directive, name, _ := strings.Cut(file, " ")
+		fmt.Fprintf(&b, "%s %s/%s\n", directive, openVPNServerDir, name)
+	}
+	fmt.Fprintln(&b, "dh none")
+	fmt.Fprintln(&b, "tls-version-min 1.2")
+	fmt.Fprintf(&b, "data-ciphers %s\n", openVPNCiphers)
+	fmt.Fprintln(&b, `push "redirect-gateway def1 bypass-dhcp"`)
+	fmt.Fprintln(&b, "keepalive 10 120")
+	fmt.Fprintln(&b, "persist-key")
+	fmt.Fprintln(&b, "persist-tun")
+	fmt.Fprintln(&b, "user nobody")
+	fmt.Fprintln(&b, "group nogroup")
+	if strings.HasPrefix(protocol, "udp") {
+		fmt.Fprintln(&b, "explicit-exit-notify 1")
+	}
+	fmt.Fprintln(&b, "verb 3")
+	return b.String()
+}
+
+// openVPNNATDropIn adds the forwarding and masquerading rules to
+// openvpn-server@server while it runs, as wg-quick does with PostUp
+func openVPNNATDropIn(cfg *Config) string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "[Service]")
+	for _, rule := range cfg.natRules(openVPNInterface) {
+		fmt.Fprintf(&b, "ExecStartPost=/usr/sbin/iptables -A %s\n", rule)
+	}
+	for _, rule := range cfg.natRules(openVPNInterface) {
+		fmt.Fprintf(&b, "ExecStopPost=-/usr/sbin/iptables -D %s\n", rule)
+	}
+	return b.String()
+}
+
+// OpenVPNCloudInit generates the cloud-init that installs OpenVPN, writes
+// the PKI, server.conf and revocation list, turns on IP forwarding and
+// enables openvpn-server@server, so the server is up on first boot. A dry
+// run neither generates the PKI nor saves a revocation list.
+func OpenVPNCloudInit(cfg *Config, profile Profile, dryRun bool) (*CloudConfig, error) {
+	load := LoadOpenVPNPKI
+	if dryRun {
+		load = planOpenVPNPKI
+	}
+	pki, err := load(cfg)
+	if err != nil {
+		return nil, err
+	}
+	crl, err := openVPNRevocationList(cfg, pki, dryRun)
+	if err != nil {
+		return nil, err
+	}
+	serverFile := func(name string, content []byte, permissions string) WriteFile {
+		return WriteFile{
+			Path:        openVPNServerDir + "/" + name,
+			Content:     string(content),
+			Owner:       "root:root",
+			Permissions: permissions,
+		}
+	}
+	return &CloudConfig{
+		PackageUpdate: true,
+		Packages:      []string{"openvpn", "iptables"},
+		WriteFiles: []WriteFile{
+			serverFile("server.conf", []byte(OpenVPNServerConf(cfg, profile)), "0644"),
+			serverFile("ca.crt", pki.CACert, "0644"),
+			serverFile("server.crt", pki.ServerCert, "0644"),
+			serverFile("server.key", pki.ServerKey, "0600"),
+			serverFile("tls-crypt.key", pki.TLSCryptKey, "0600"),
+			// Read after privileges are dropped to nobody
+			serverFile("crl.pem", crl, "0644"),
+			{
+				Path:        "/etc/systemd/system/openvpn-server@server.service.d/nat.conf",
+				Content:     openVPNNATDropIn(cfg),
+				Permissions: "0644",
+			},
+			{
+				Path:        "/etc/sysctl.d/99-openvpn.conf",
+				Content:     "net.ipv4.ip_forward = 1\n",
+				Permissions: "0644",
+			},
+		},
+		RunCmd: []string{
+			"sysctl --system",
+			"systemctl daemon-reload",
+			"systemctl enable --now openvpn-server@server",
+		},
+	}, nil
+}
+
+// OpenVPNServerInfo describes the server for the end of deploy
+func OpenVPNServerInfo(cfg *Config, profile Profile) (string, error) {
+	pki, err := LoadOpenVPNPKI(cfg)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("OpenVPN CA fingerprint (SHA-256): %s\nOpenVPN CA valid until: %s\nOpenVPN tunnel: %s\n",
+		certFingerprint(pki.ca.Cert), pki.ca.Cert.NotAfter.Format("2006-01-02"), cfg.ServerTunnelAddress()), nil
+}
@@ -0,0 +1,347 @@
+package utils
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// openVPNClient is a client as recorded in clients.json. Its key and
+// certificate are only kept in its .ovpn profile.
+type openVPNClient struct {
+	Name        string     `json:"name"`
+	Serial      string     `json:"serial"`
+	Fingerprint string     `json:"fingerprint"`
+	SplitTunnel []string   `json:"splitTunnel,omitempty"`
+	ExpiresAt   time.Time  `json:"expiresAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	RevokedAt   *time.Time `json:"revokedAt,omitempty"`
+}
+
+type openVPNClientFile struct {
+	Clients []openVPNClient `json:"clients"`
+	// Revoked keeps removed clients for the revocation list
+	Revoked []openVPNClient `json:"revoked,omitempty"`
+}
+
+// OpenVPNPeers is the PeerManager of the OpenVPN flavor. Clients get a
+// certificate from the local CA and an .ovpn profile with everything inline.
+// Removed clients are revoked, Sync pushes the revocation list.
+type OpenVPNPeers struct{}
+
+func loadOpenVPNClients(cfg *Config) (*openVPNClientFile, error) {
+	path := openVPNPath(cfg, "clients.json")
+	file := &openVPNClientFile{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return file, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenVPN clients: %v", err)
+	}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return file, nil
+}
+
+// find returns the index of the client called name, or -1
+func (f *openVPNClientFile) find(name string) int {
+	for i, client := range f.Clients {
+		if strings.EqualFold(client.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ovpnPath returns where a client's profile is written
+func ovpnPath(cfg *Config, name string) string {
+	return openVPNPath(cfg, filepath.Join("clients", name+".ovpn"))
+}
+
+// Add issues a client certificate and writes its .ovpn profile
+func (OpenVPNPeers) Add(cfg *Config, profile Profile, name string, opts PeerOptions) (*ClientProfile, error) {
+	if err := ValidatePeerName(name); err != nil {
+		return nil, err
+	}
+	if opts.PresharedKey {
+		return nil, fmt.Errorf("OpenVPN clients always share the tls-crypt key, a preshared key is only for WireGuard")
+	}
+	clients, err := loadOpenVPNClients(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if i := clients.find(name); i >= 0 {
+		return nil, fmt.Errorf("peer %s already exists, remove it first to replace it", clients.Clients[i].Name)
+	}
+	for _, cidr := range opts.SplitTunnel {
+		prefix, problem := parsePrefix("split tunnel CIDR", cidr)
+		if problem != "" {
+			return nil, errors.New(problem)
+		}
+		if !prefix.Addr().Is4() {
+			return nil, fmt.Errorf("split tunnel CIDR %s is not IPv4, the OpenVPN tunnel only carries IPv4", cidr)
+		}
+	}
+	dns := opts.DNS
+	if len(dns) == 0 {
+		dns = cfg.ClientDNS()
+	}
+	for _, server := range dns {
+		if _, err := netip.ParseAddr(server); err != nil {
+			return nil, fmt.Errorf("DNS server %q is not an IP address", server)
+		}
+	}
+
+	pki, err := LoadOpenVPNPKI(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cert, key, err := pki.ca.issue(name, cfg.KeyType(), cfg.CertDays(), x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	keyPEMData, err := keyPEM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &ClientProfile{
+		Name:    name,
+		Path:    ovpnPath(cfg, name),
+		Content: []byte(openVPNClientConf(profile, pki, opts.Endpoint, dns, opts.SplitTunnel, certPEM(cert), keyPEMData)),
+	}
+	if err := writeSecretFile(client.Path, client.Content); err != nil {
+		return nil, err
+	}
+	clients.Clients = append(clients.Clients, openVPNClient{
+		Name:        name,
+		Serial:      cert.SerialNumber.Text(16),
+		Fingerprint: certFingerprint(cert),
+		SplitTunnel: opts.SplitTunnel,
+		ExpiresAt:   cert.NotAfter,
+		CreatedAt:   time.Now().UTC(),
+	})
+	if err := writeSecret(openVPNPath(cfg, "clients.json"), clients); err != nil {
+		return nil, err
+	}
+	InfoLogger.Printf("Added peer %s with a certificate valid until %s", name, cert.NotAfter.Format("2006-01-02"))
+	return client, nil
+}
+
+// Client reads the profile of a recorded client
+func (OpenVPNPeers) Client(cfg *Config, name string) (*ClientProfile, error) {
+	clients, err := loadOpenVPNClients(cfg)
+	if err != nil {
+		return nil, err
+	}
+	i := clients.find(name)
+	if i < 0 {
+		return nil, fmt.Errorf("no peer called %s", name)
+	}
+	client := &ClientProfile{Name: clients.Clients[i].Name, Path: ovpnPath(cfg, clients.Clients[i].Name)}
+	if client.Content, err = os.ReadFile(client.Path); err != nil {
+		return nil, fmt.Errorf("failed to read the profile of peer %s: %v", client.Name, err)
+	}
+	return client, nil
+}
+
+// Remove revokes a client's certificate and deletes its profile and QR code
+func (OpenVPNPeers) Remove(cfg *Config, name string) error {
+	clients, err := loadOpenVPNClients(cfg)
+	if err != nil {
+		return err
+	}
+	i := clients.find(name)
+	if i < 0 {
+		return fmt.Errorf("no peer called %s", name)
+	}
+	removed := clients.Clients[i]
+	profile := ClientProfile{Path: ovpnPath(cfg, removed.Name)}
+	for _, path := range []string{profile.Path, profile.QRCodePath()} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s of peer %s: %v", path, removed.Name, err)
+		}
+	}
+	revokedAt := time.Now().UTC()
+	removed.RevokedAt = &revokedAt
+	clients.Clients = append(clients.Clients[:i], clients.Clients[i+1:]...)
+	clients.Revoked = append(clients.Revoked, removed)
+	InfoLogger.Printf("Revoked peer %s with certificate serial %s", removed.Name, removed.Serial)
+	return writeSecret(openVPNPath(cfg, "clients.json"), clients)
+}
+
+// List returns the recorded clients that are not revoked
+func (OpenVPNPeers) List(cfg *Config) ([]PeerInfo, error) {
+	clients, err := loadOpenVPNClients(cfg)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]PeerInfo, 0, len(clients.Clients))
+	for _, client := range clients.Clients {
+		infos = append(infos, PeerInfo{
+			Name:        client.Name,
+			PublicKey:   client.Fingerprint,
+			SplitTunnel: len(client.SplitTunnel) > 0,
+			CreatedAt:   client.CreatedAt,
+		})
+	}
+	return infos, nil
+}
+
+// Sync replaces the revocation list on the server. The server accepts every
+// certificate of the CA, so only removals need it. OpenVPN reads the new
+// list on the next handshake, connected clients are not dropped.
+func (OpenVPNPeers) Sync(ctx context.Context, cred azcore.TokenCredential, cfg *Config, profile Profile) error {
+	pki, err := LoadOpenVPNPKI(cfg)
+	if err != nil {
+		return err
+	}
+	crl, err := openVPNRevocationList(cfg, pki, false)
+	if err != nil {
+		return err
+	}
+	path := openVPNServerDir + "/crl.pem"
+	script := strings.Join([]string{
+		"umask 022",
+		fmt.Sprintf("cat > %s.new <<'CRL'", path),
+		strings.TrimRight(string(crl), "\n"),
+		"CRL",
+		fmt.Sprintf("mv %s.new %s", path, path),
+	}, "\n")
+	if _, err := RunShellScript(ctx, cred, cfg, script); err != nil {
+		return err
+	}
+	clients, err := loadOpenVPNClients(cfg)
+	if err != nil {
+		return err
+	}
+	InfoLogger.Printf("Server %s now has %d revoked certificates", cfg.VMName, len(clients.Revoked))
+	return nil
+}
+
+// openVPNRevocationList returns the CRL of the removed clients. It is signed
+// once and kept in crl.pem until a removal changes it, as every signature
+// differs and would change the cloud-init, and with it the VM input deploy
+// compares on --resume. A dry run signs a changed list without saving it, or
+// returns a placeholder when the PKI is one too.
+func openVPNRevocationList(cfg *Config, pki *OpenVPNPKI, dryRun bool) ([]byte, error) {
+	if pki.ca == nil {
+		return []byte(plannedSecret + "\n"), nil
+	}
+	clients, err := loadOpenVPNClients(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// Every removal grows the list, so its length numbers it
+	number := big.NewInt(int64(len(clients.Revoked)) + 1)
+	path := openVPNPath(cfg, "crl.pem")
+	saved, list, err := readOpenVPNRevocationList(path, pki)
+	if err != nil {
+		return nil, err
+	}
+	if list != nil && list.Number.Cmp(number) == 0 {
+		return saved, nil
+	}
+
+	var revoked []x509.RevocationListEntry
+	for _, client := range clients.Revoked {
+		serial, ok := new(big.Int).SetString(client.Serial, 16)
+		if !ok {
+			return nil, fmt.Errorf("peer %s has an invalid certificate serial %q", client.Name, client.Serial)
+		}
+		revokedAt := client.CreatedAt
+		if client.RevokedAt != nil {
+			revokedAt = *client.RevokedAt
+		}
+		revoked = append(revoked, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: revokedAt})
+	}
+	crl, err := pki.ca.revocationList(revoked, number.Int64())
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return crl, nil
+	}
+	if err := writeSecretFile(path, crl); err != nil {
+		return nil, err
+	}
+	InfoLogger.Printf("Signed revocation list %s with %d revoked certificates, saved to %s", number, len(revoked), path)
+	return crl, nil
+}
+
+// readOpenVPNRevocationList reads the saved CRL, nil when there is none or
+// it was not signed by the current CA
+func readOpenVPNRevocationList(path string, pki *OpenVPNPKI) ([]byte, *x509.RevocationList, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read revocation list: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "X509 CRL" {
+		return nil, nil, fmt.Errorf("%s does not hold a PEM x509 crl", path)
+	}
+	list, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if list.CheckSignatureFrom(pki.ca.Cert) != nil {
+		return nil, nil, nil
+	}
+	return data, list, nil
+}
+
+// openVPNClientConf renders the .ovpn profile a client imports, with the CA,
+// its certificate and key and the tls-crypt key inline. A split tunnel
+// ignores the default route the server pushes and routes only its CIDRs.
+func openVPNClientConf(profile Profile, pki *OpenVPNPKI, endpoint string, dns, split []string, cert, key []byte) string {
+	host, port, _ := net.SplitHostPort(endpoint)
+
+	var b strings.Builder
+	fmt.Fprintln(&b, "client")
+	fmt.Fprintln(&b, "dev tun")
+	fmt.Fprintf(&b, "proto %s\n", strings.ToLower(profile.VPNProtocol))
+	fmt.Fprintf(&b, "remote %s %s\n", host, port)
+	fmt.Fprintln(&b, "resolv-retry infinite")
+	fmt.Fprintln(&b, "nobind")
+	fmt.Fprintln(&b, "persist-key")
+	fmt.Fprintln(&b, "persist-tun")
+	fmt.Fprintln(&b, "remote-cert-tls server")
+	fmt.Fprintf(&b, "verify-x509-name %s name\n", openVPNServerName)
+	fmt.Fprintln(&b, "tls-version-min 1.2")
+	fmt.Fprintf(&b, "data-ciphers %s\n", openVPNCiphers)
+	for _, server := range dns {
+		fmt.Fprintf(&b, "dhcp-option DNS %s\n", server)
+	}
+	if len(split) > 0 {
+		fmt.Fprintln(&b, `pull-filter ignore "redirect-gateway"`)
+		for _, cidr := range split {
+			prefix := netip.MustParsePrefix(cidr)
+			fmt.Fprintf(&b, "route %s %s\n", prefix.Addr(), net.IP(net.CIDRMask(prefix.Bits(), 32)))
+		}
+	}
+	fmt.Fprintln(&b, "verb 3")
+	for _, inline := range []struct {
+		tag     string
+		content []byte
+	}{{"ca", pki.CACert}, {"cert", cert}, {"key", key}, {"tls-crypt", pki.TLSCryptKey}} {
+		fmt.Fprintf(&b, "<%s>\n%s</%s>\n", inline.tag, inline.content, inline.tag)
+	}
+	return b.String()
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// DefaultClientDNS is the resolver clients use when CLIENT_DNS is not set
+const DefaultClientDNS = "1.1.1.1"
+
+// PeerManager manages the VPN clients of a flavor, see Profile.Peers. The
+// clients are recorded locally, the server only learns about them on Sync.
+type PeerManager interface {
+	// Add creates a client, records it and writes its config file
+	Add(cfg *Config, profile Profile, name string, opts PeerOptions) (*ClientProfile, error)
+	// Client returns the config of a recorded client
+	Client(cfg *Config, name string) (*ClientProfile, error)
+	// Remove forgets a client and deletes its config file and QR code
+	Remove(cfg *Config, name string) error
+	// List returns the recorded clients in the order they were added
+	List(cfg *Config) ([]PeerInfo, error)
+	// Sync makes the server's client list match the recorded clients
+	Sync(ctx context.Context, cred azcore.TokenCredential, cfg *Config, profile Profile) error
+}
+
+// PeerOptions are the choices made when adding a client
+type PeerOptions struct {
+	// Endpoint is the host:port the client connects to
+	Endpoint string
+	// DNS lists the resolvers the client uses while connected
+	DNS []string
+	// SplitTunnel lists the CIDRs routed through the VPN besides the
+	// tunnel itself. Empty means a full tunnel, all traffic goes through.
+	SplitTunnel []string
+	// PresharedKey adds a symmetric key on top of the public keys
+	PresharedKey bool
+}
+
+// PeerInfo describes a recorded client
+type PeerInfo struct {
+	Name string `json:"name"`
+	// Address is the client's tunnel address, empty when the server hands
+	// them out
+	Address string `json:"address"`
+	// PublicKey identifies the client: its WireGuard public key or the
+	// fingerprint of its certificate
+	PublicKey    string    `json:"publicKey"`
+	PresharedKey bool      `json:"presharedKey"`
+	SplitTunnel  bool      `json:"splitTunnel"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// ClientProfile is the config file handed to a client
+type ClientProfile struct {
+	Name    string
+	Path    string
+	Content []byte
+}
+
+var peerNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+// ValidatePeerName checks a client name, which is also used as a file name
+func ValidatePeerName(name string) error {
+	if !peerNamePattern.MatchString(name) {
+		return fmt.Errorf("peer name %q must be up to 64 letters, digits, '.', '_' or '-', starting with a letter or digit", name)
+	}
+	return nil
+}
+
+// ClientDNS returns CLIENT_DNS or the default
+func (c *Config) ClientDNS() []string {
+	return splitList(orDefault(c.ClientDNSServers, DefaultClientDNS))
+}
+
+// validateClients checks the client settings
+func (c *Config) validateClients() []string {
+	var problems []string
+	for _, server := range splitList(c.ClientDNSServers) {
+		if _, err := netip.ParseAddr(server); err != nil {
+			problems = append(problems, fmt.Sprintf("CLIENT_DNS entry %q is not an IP address", server))
+		}
+	}
+	return problems
+}
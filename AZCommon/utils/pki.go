@@ -0,0 +1,277 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PKI key types, see PKI_KEY_TYPE
+const (
+	KeyTypeECDSA = "ecdsa"
+	KeyTypeRSA   = "rsa"
+)
+
+// PKI defaults: P-256 keys, a CA valid for ten years and certificates for
+// 825 days, the longest many clients accept
+const (
+	DefaultKeyType  = KeyTypeECDSA
+	DefaultCADays   = 3650
+	DefaultCertDays = 825
+)
+
+// rsaKeyBits is the size of RSA keys
+const rsaKeyBits = 3072
+
+// KeyTypeValues lists the accepted PKI_KEY_TYPE values
+func KeyTypeValues() []string {
+	return []string{KeyTypeECDSA, KeyTypeRSA}
+}
+
+// KeyType returns PKI_KEY_TYPE or the default
+func (c *Config) KeyType() string {
+	return strings.ToLower(orDefault(c.PKIKeyType, DefaultKeyType))
+}
+
+// CADays returns PKI_CA_DAYS or the default. Validate has checked it.
+func (c *Config) CADays() int {
+	return daysOrDefault(c.PKICADays, DefaultCADays)
+}
+
+// CertDays returns PKI_CERT_DAYS or the default. Validate has checked it.
+func (c *Config) CertDays() int {
+	return daysOrDefault(c.PKICertDays, DefaultCertDays)
+}
+
+func daysOrDefault(value string, fallback int) int {
+	if days, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		return days
+	}
+	return fallback
+}
+
+// validatePKI checks the PKI settings
+func (c *Config) validatePKI() []string {
+	var problems []string
+	if !slices.Contains(KeyTypeValues(), c.KeyType()) {
+		problems = append(problems, fmt.Sprintf("PKI_KEY_TYPE %q is not one of %s", c.PKIKeyType, strings.Join(KeyTypeValues(), ", ")))
+	}
+	daysValid := true
+	for _, setting := range [][2]string{{"PKI_CA_DAYS", c.PKICADays}, {"PKI_CERT_DAYS", c.PKICertDays}} {
+		if setting[1] == "" {
+			continue
+		}
+		if days, err := strconv.Atoi(strings.TrimSpace(setting[1])); err != nil || days < 1 {
+			problems = append(problems, fmt.Sprintf("%s %q is not a positive number of days", setting[0], setting[1]))
+			daysValid = false
+		}
+	}
+	if daysValid && c.CertDays() > c.CADays() {
+		problems = append(problems, fmt.Sprintf("PKI_CERT_DAYS %d is longer than PKI_CA_DAYS %d", c.CertDays(), c.CADays()))
+	}
+	return problems
+}
+
+// generateKey creates a private key of the given type
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case KeyTypeRSA:
+		return rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case KeyTypeECDSA:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	return nil, fmt.Errorf("unknown key type %q", keyType)
+}
+
+// certificateAuthority is a CA whose key is held locally
+type certificateAuthority struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// newSerial returns a random 128 bit serial number
+func newSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+	return serial, nil
+}
+
+// newCertificateAuthority creates a self-signed CA
+func newCertificateAuthority(commonName, keyType string, days int) (*certificateAuthority, error) {
+	key, err := generateKey(keyType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key: %v", err)
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(0, 0, days),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &certificateAuthority{Cert: cert, Key: key}, nil
+}
+
+// issue creates a key and a certificate signed by the CA, for a server or a
+// client. The certificate never outlives the CA.
+func (ca *certificateAuthority) issue(commonName, keyType string, days int, usage x509.ExtKeyUsage) (*x509.Certificate, crypto.Signer, error) {
+	key, err := generateKey(keyType)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key for %s: %v", commonName, err)
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now().UTC()
+	notAfter := now.AddDate(0, 0, days)
+	if notAfter.After(ca.Cert.NotAfter) {
+		notAfter = ca.Cert.NotAfter
+	}
+	keyUsage := x509.KeyUsageDigitalSignature
+	if keyType == KeyTypeRSA {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           []x509.ExtKeyUsage{usage},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, key.Public(), ca.Key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate for %s: %v", commonName, err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// revocationList returns a CRL of the given serials, valid as long as the
+// CA, since OpenVPN refuses every client once its CRL has expired
+func (ca *certificateAuthority) revocationList(revoked []x509.RevocationListEntry, number int64) ([]byte, error) {
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificateEntries: revoked,
+		Number:                    big.NewInt(number),
+		ThisUpdate:                time.Now().UTC().Add(-time.Hour),
+		NextUpdate:                ca.Cert.NotAfter,
+	}, ca.Cert, ca.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create revocation list: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), nil
+}
+
+// certPEM encodes a certificate
+func certPEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+// keyPEM encodes a private key as PKCS#8
+func keyPEM(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// readCertPEM reads the certificate in a PEM file
+func readCertPEM(path string) (*x509.Certificate, error) {
+	block, err := readPEM(path, "CERTIFICATE")
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return cert, nil
+}
+
+// readKeyPEM reads the PKCS#8 private key in a PEM file
+func readKeyPEM(path string) (crypto.Signer, error) {
+	block, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s does not hold a signing key", path)
+	}
+	return signer, nil
+}
+
+func readPEM(path, blockType string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s does not hold a PEM %s", path, strings.ToLower(blockType))
+	}
+	return block, nil
+}
+
+// certFingerprint returns the SHA-256 fingerprint of a certificate in the
+// colon separated form openssl prints
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	pairs := make([]string, len(sum))
+	for i, b := range sum {
+		pairs[i] = strings.ToUpper(hex.EncodeToString([]byte{b}))
+	}
+	return strings.Join(pairs, ":")
+}
+
+// fileExists reports whether path exists, failing on any other error
+func fileExists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
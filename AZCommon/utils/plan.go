@@ -0,0 +1,283 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/consumption/armconsumption"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+// Resource types used in plans and resource IDs
+const (
+	TypeResourceGroup  = "Microsoft.Resources/resourceGroups"
+	TypeVirtualNetwork = "Microsoft.Network/virtualNetworks"
+	TypeSubnet         = "Microsoft.Network/virtualNetworks/subnets"
+	TypePublicIP       = "Microsoft.Network/publicIPAddresses"
+	TypeNSG            = "Microsoft.Network/networkSecurityGroups"
+	TypeSecurityRule   = "Microsoft.Network/networkSecurityGroups/securityRules"
+	TypeNIC            = "Microsoft.Network/networkInterfaces"
+	TypeVM             = "Microsoft.Compute/virtualMachines"
+)
+
+// ResourceGroupID returns the ID of the configured resource group
+func ResourceGroupID(cfg *Config) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s", cfg.SubscriptionID, cfg.ResourceGroupName)
+}
+
+// ResourceID returns the ID of a resource in the configured resource group.
+// resourceType is a full type such as TypeSubnet and names holds one name per
+// type segment, e.g. the vnet and subnet names for a subnet.
+func ResourceID(cfg *Config, resourceType string, names ...string) string {
+	provider, types, _ := strings.Cut(resourceType, "/")
+	segments := strings.Split(types, "/")
+	var b strings.Builder
+	b.WriteString(ResourceGroupID(cfg))
+	b.WriteString("/providers/")
+	b.WriteString(provider)
+	for i, segment := range segments {
+		if i < len(names) {
+			fmt.Fprintf(&b, "/%s/%s", segment, names[i])
+		}
+	}
+	return b.String()
+}
+
+// PlannedResource is a single request the deployment would send to Azure
+type PlannedResource struct {
+	Type      string   `json:"type"`
+	Name      string   `json:"name"`
+	ID        string   `json:"id"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+	Body      any      `json:"body"`
+}
+
+// Plan is the full resource graph of a deployment, in creation order
+type Plan struct {
+	Profile        string            `json:"profile"`
+	SubscriptionID string            `json:"subscriptionId"`
+	ResourceGroup  string            `json:"resourceGroup"`
+	Location       string            `json:"location"`
+	Resources      []PlannedResource `json:"resources"`
+	// Estimate is the monthly cost estimate, when one was requested
+	Estimate *CostEstimate `json:"estimate,omitempty"`
+}
+
+// BuildPlan builds every request body the deployment would send, using the
+// same builders as the Create functions, without contacting Azure or
+// writing anything. It fails when the NSG rules or the cloud-init document
+// are invalid. The cloud-init holds the server keys, so the plan only shows
+// its size and hash, see RedactCustomData.
+func BuildPlan(cfg *Config, profile Profile) (*Plan, error) {
+	rules, err := NetSecRules(cfg, profile)
+	if err != nil {
+		return nil, err
+	}
+	customData, err := CustomData(cfg, profile, true)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		Profile:        profile.Name,
+		SubscriptionID: cfg.SubscriptionID,
+		ResourceGroup:  cfg.ResourceGroupName,
+		Location:       cfg.Location,
+	}
+
+	rgID := ResourceGroupID(cfg)
+	vnetID := ResourceID(cfg, TypeVirtualNetwork, cfg.VnetName)
+	subnetID := ResourceID(cfg, TypeSubnet, cfg.VnetName, cfg.SubnetName)
+	publicIPID := ResourceID(cfg, TypePublicIP, cfg.PublicIPName)
+	publicIPv6ID := ResourceID(cfg, TypePublicIP, cfg.PublicIPv6Name())
+	nsgID := ResourceID(cfg, TypeNSG, cfg.NSGName)
+	nicID := ResourceID(cfg, TypeNIC, cfg.NICName)
+
+	plan.add(TypeResourceGroup, cfg.ResourceGroupName, rgID, armresources.ResourceGroup{
+		Location: to.Ptr(cfg.Location),
+	})
+	if budget, err := cfg.Budget(); err == nil && budget != nil {
+		plan.add(TypeBudget, budget.Name, ResourceID(cfg, TypeBudget, budget.Name), BudgetParams(budget, budgetStart(time.Now())), rgID)
+	}
+	plan.add(TypeVirtualNetwork, cfg.VnetName, vnetID, VnetParams(cfg), rgID)
+	plan.add(TypeNSG, cfg.NSGName, nsgID, NsgParams(cfg, rules), rgID)
+	subnetDependsOn := []string{vnetID}
+	if cfg.AttachNSGToSubnet() {
+		subnetDependsOn = append(subnetDependsOn, nsgID)
+	}
+	plan.add(TypeSubnet, cfg.SubnetName, subnetID, SubnetParams(cfg, nsgID), subnetDependsOn...)
+	plan.add(TypePublicIP, cfg.PublicIPName, publicIPID, PublicIPParams(cfg), rgID)
+	nicDependsOn := []string{subnetID, publicIPID}
+	if cfg.DualStack() {
+		plan.add(TypePublicIP, cfg.PublicIPv6Name(), publicIPv6ID, PublicIPv6Params(cfg), rgID)
+		nicDependsOn = append(nicDependsOn, publicIPv6ID)
+	}
+	if cfg.AttachNSGToNIC() {
+		nicDependsOn = append(nicDependsOn, nsgID)
+	}
+	plan.add(TypeNIC, cfg.NICName, nicID, NICParams(cfg, subnetID, publicIPID, publicIPv6ID, nsgID), nicDependsOn...)
+	plan.add(TypeVM, cfg.VMName, ResourceID(cfg, TypeVM, cfg.VMName), VMParams(cfg, nicID, RedactCustomData(customData)), nicID)
+	return plan, nil
+}
+
+func (p *Plan) add(resourceType, name, id string, body any, dependsOn ...string) {
+	p.Resources = append(p.Resources, PlannedResource{
+		Type:      resourceType,
+		Name:      name,
+		ID:        id,
+		DependsOn: dependsOn,
+		Body:      body,
+	})
+}
+
+// WriteJSON writes the plan, including the raw request bodies, as JSON
+func (p *Plan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// WriteText writes a human readable summary of the plan for review
+func (p *Plan) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "%s deployment plan\n", p.Profile)
+	fmt.Fprintln(w, "============================================")
+	fmt.Fprintf(w, "Subscription:   %s\n", p.SubscriptionID)
+	fmt.Fprintf(w, "Resource group: %s\n", p.ResourceGroup)
+	fmt.Fprintf(w, "Location:       %s\n", p.Location)
+	fmt.Fprintf(w, "\nThe following %d resources will be created:\n", len(p.Resources))
+
+	for _, res := range p.Resources {
+		fmt.Fprintf(w, "\n+ %s %q\n", res.Type, res.Name)
+		for _, detail := range describeBody(res.Body) {
+			fmt.Fprintf(w, "    %s\n", detail)
+		}
+		for _, dep := range res.DependsOn {
+			fmt.Fprintf(w, "    depends on: %s\n", shortID(dep))
+		}
+	}
+
+	if p.Estimate != nil {
+		fmt.Fprintln(w)
+		p.Estimate.WriteText(w)
+	}
+}
+
+// shortID trims the subscription and resource group from a resource ID
+func shortID(id string) string {
+	if _, rest, ok := strings.Cut(id, "/providers/"); ok {
+		return rest
+	}
+	return id[strings.Index(id, "/resourceGroups/")+1:]
+}
+
+// describeBody returns the interesting settings of a request body
+func describeBody(body any) []string {
+	switch b := body.(type) {
+	case armnetwork.VirtualNetwork:
+		return []string{"address space: " + strings.Join(derefAll(b.Properties.AddressSpace.AddressPrefixes), ", ")}
+	case armnetwork.Subnet:
+		prefixes := derefAll(b.Properties.AddressPrefixes)
+		if b.Properties.AddressPrefix != nil {
+			prefixes = append([]string{*b.Properties.AddressPrefix}, prefixes...)
+		}
+		lines := []string{"address prefix: " + strings.Join(prefixes, ", ")}
+		if b.Properties.NetworkSecurityGroup != nil {
+			lines = append(lines, "nsg: "+shortID(*b.Properties.NetworkSecurityGroup.ID))
+		}
+		return lines
+	case armnetwork.SecurityGroup:
+		var lines []string
+		for _, rule := range b.Properties.SecurityRules {
+			lines = append(lines, fmt.Sprintf("rule %s, %s", *rule.Name, DescribeRule(*rule)))
+		}
+		return lines
+	case armnetwork.PublicIPAddress:
+		line := fmt.Sprintf("sku: %s, allocation: %s", *b.SKU.Name, *b.Properties.PublicIPAllocationMethod)
+		if b.Properties.PublicIPAddressVersion != nil {
+			line += ", version: " + string(*b.Properties.PublicIPAddressVersion)
+		}
+		return []string{line}
+	case armnetwork.SecurityRule:
+		return []string{DescribeRule(b)}
+	case armnetwork.Interface:
+		lines := []string{fmt.Sprintf("ip configurations: %d", len(b.Properties.IPConfigurations))}
+		if b.Properties.NetworkSecurityGroup != nil {
+			lines = append(lines, "nsg: "+shortID(*b.Properties.NetworkSecurityGroup.ID))
+		}
+		return lines
+	case armconsumption.Budget:
+		var thresholds []float64
+		var emails []string
+		for _, notification := range b.Properties.Notifications {
+			thresholds = append(thresholds, *notification.Threshold)
+			emails = derefAll(notification.ContactEmails)
+		}
+		sort.Float64s(thresholds)
+		return []string{
+			fmt.Sprintf("amount: %.2f, time grain: %s", *b.Properties.Amount, *b.Properties.TimeGrain),
+			fmt.Sprintf("alerts at %s of actual cost to %s", formatPercentages(thresholds), strings.Join(emails, ", ")),
+		}
+	case armcompute.VirtualMachine:
+		image := b.Properties.StorageProfile.ImageReference
+		lines := []string{
+			"size: " + string(*b.Properties.HardwareProfile.VMSize),
+			fmt.Sprintf("image: %s:%s:%s:%s", *image.Publisher, *image.Offer, *image.SKU, *image.Version),
+			"os disk: " + string(*b.Properties.StorageProfile.OSDisk.ManagedDisk.StorageAccountType),
+			"admin user: " + *b.Properties.OSProfile.AdminUsername,
+		}
+		if customData := b.Properties.OSProfile.CustomData; customData != nil {
+			lines = append(lines, "cloud-init: "+*customData)
+		}
+		return lines
+	}
+	return nil
+}
+
+// DescribeRule formats a security rule as a single line
+func DescribeRule(rule armnetwork.SecurityRule) string {
+	props := rule.Properties
+	if props == nil {
+		return "no properties"
+	}
+	return fmt.Sprintf("priority %d: %s %s %s port %s from %s to %s",
+		rulePriority(&rule), stringValue(props.Direction), stringValue(props.Access), stringValue(props.Protocol),
+		rulePorts(props), ruleSources(props), ruleDestinations(props))
+}
+
+func rulePorts(props *armnetwork.SecurityRulePropertiesFormat) string {
+	return joinPrefixes(props.DestinationPortRange, props.DestinationPortRanges)
+}
+
+func ruleSources(props *armnetwork.SecurityRulePropertiesFormat) string {
+	return joinPrefixes(props.SourceAddressPrefix, props.SourceAddressPrefixes)
+}
+
+func ruleDestinations(props *armnetwork.SecurityRulePropertiesFormat) string {
+	return joinPrefixes(props.DestinationAddressPrefix, props.DestinationAddressPrefixes)
+}
+
+// joinPrefixes formats the single/plural pair Azure uses for rule fields
+func joinPrefixes(single *string, plural []*string) string {
+	if single != nil && *single != "" {
+		return *single
+	}
+	return strings.Join(derefAll(plural), ",")
+}
+
+// derefAll converts a slice of string pointers as used by the SDK to strings
+func derefAll(values []*string) []string {
+	var out []string
+	for _, v := range values {
+		if v != nil {
+			out = append(out, *v)
+		}
+	}
+	return out
+}
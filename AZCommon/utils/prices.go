@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// RetailPricesURL is the endpoint of the Azure Retail Prices API
+const RetailPricesURL = "https://prices.azure.com/api/retail/prices"
+
+// PriceItem is a single price in the Azure Retail Prices API format
+type PriceItem struct {
+	CurrencyCode     string  `json:"currencyCode"`
+	TierMinimumUnits float64 `json:"tierMinimumUnits"`
+	RetailPrice      float64 `json:"retailPrice"`
+	UnitPrice        float64 `json:"unitPrice"`
+	ArmRegionName    string  `json:"armRegionName"`
+	ProductName      string  `json:"productName"`
+	SkuName          string  `json:"skuName"`
+	ServiceName      string  `json:"serviceName"`
+	MeterName        string  `json:"meterName"`
+	ArmSkuName       string  `json:"armSkuName"`
+	UnitOfMeasure    string  `json:"unitOfMeasure"`
+	Type             string  `json:"type"`
+}
+
+// PriceQuery selects prices, empty fields match anything. Only consumption
+// (pay as you go) prices are returned.
+type PriceQuery struct {
+	ServiceName   string
+	ArmRegionName string
+	ProductName   string
+	SkuName       string
+	ArmSkuName    string
+	MeterName     string
+}
+
+// PriceSource looks up retail prices
+type PriceSource interface {
+	Prices(ctx context.Context, query PriceQuery) ([]PriceItem, error)
+}
+
+// priceSheet is the response of the Retail Prices API, also used as the
+// format of local price sheets
+type priceSheet struct {
+	Items        []PriceItem `json:"Items"`
+	NextPageLink string      `json:"NextPageLink"`
+}
+
+// RetailPriceSource reads prices from the Azure Retail Prices API, which
+// needs no authentication
+type RetailPriceSource struct {
+	// Currency is an ISO currency code such as "EUR", USD when empty
+	Currency string
+	Client   *http.Client
+}
+
+// Prices queries the Retail Prices API, following every result page
+func (s *RetailPriceSource) Prices(ctx context.Context, query PriceQuery) ([]PriceItem, error) {
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	params := url.Values{}
+	params.Set("$filter", query.filter())
+	if s.Currency != "" {
+		params.Set("currencyCode", s.Currency)
+	}
+	next := RetailPricesURL + "?" + params.Encode()
+
+	var items []PriceItem
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query retail prices: %v", err)
+		}
+		var page priceSheet
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			return nil, fmt.Errorf("retail prices API returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse retail prices: %v", err)
+		}
+		items = append(items, page.Items...)
+		next = page.NextPageLink
+	}
+	return items, nil
+}
+
+// filter builds the OData filter of the Retail Prices API
+func (q PriceQuery) filter() string {
+	clauses := []string{"priceType eq 'Consumption'"}
+	for _, field := range q.fields() {
+		if field.value != "" {
+			clauses = append(clauses, fmt.Sprintf("%s eq '%s'", field.name, strings.ReplaceAll(field.value, "'", "''")))
+		}
+	}
+	return strings.Join(clauses, " and ")
+}
+
+type priceField struct {
+	name, value string
+}
+
+func (q PriceQuery) fields() []priceField {
+	return []priceField{
+		{"serviceName", q.ServiceName},
+		{"armRegionName", q.ArmRegionName},
+		{"productName", q.ProductName},
+		{"skuName", q.SkuName},
+		{"armSkuName", q.ArmSkuName},
+		{"meterName", q.MeterName},
+	}
+}
+
+// matches reports whether item is selected by the query
+func (q PriceQuery) matches(item PriceItem) bool {
+	if item.Type != "" && item.Type != "Consumption" {
+		return false
+	}
+	values := map[string]string{
+		"serviceName":   item.ServiceName,
+		"armRegionName": item.ArmRegionName,
+		"productName":   item.ProductName,
+		"skuName":       item.SkuName,
+		"armSkuName":    item.ArmSkuName,
+		"meterName":     item.MeterName,
+	}
+	for _, field := range q.fields() {
+		if field.value != "" && !strings.EqualFold(field.value, values[field.name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// LocalPriceSource reads prices from a JSON file for offline use. The file
+// holds either a saved Retail Prices API response ({"Items": [...]}) or just
+// the array of items.
+type LocalPriceSource struct {
+	items []PriceItem
+}
+
+// LoadPriceSheet reads a local price sheet
+func LoadPriceSheet(path string) (*LocalPriceSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read price sheet: %v", err)
+	}
+
+	var sheet priceSheet
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "[") {
+		err = json.Unmarshal(data, &sheet.Items)
+	} else {
+		err = json.Unmarshal(data, &sheet)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse price sheet %s: %v", path, err)
+	}
+	return &LocalPriceSource{items: sheet.Items}, nil
+}
+
+// Prices returns the items of the sheet matching the query
+func (s *LocalPriceSource) Prices(ctx context.Context, query PriceQuery) ([]PriceItem, error) {
+	var items []PriceItem
+	for _, item := range s.items {
+		if query.matches(item) {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
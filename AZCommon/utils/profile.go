@@ -0,0 +1,27 @@
+package utils
+
+// Profile describes everything that differs between the VPN flavors built on
+// top of these utils. The WireGuard and OpenVPN binaries only supply a Profile
+// and hand it to app.Run.
+type Profile struct {
+	// Name is the human readable flavor name, e.g. "OpenVPN"
+	Name string
+	// LogPrefix is used for the log file name, e.g. "azure_ovpn"
+	LogPrefix string
+	// RuleName is the NSG rule name used for the VPN port
+	RuleName string
+	// VPNPort and VPNProtocol describe the port the VPN server listens on
+	VPNPort     int
+	VPNProtocol string
+	// CloudInit generates the cloud-init document that sets the server up
+	// on first boot. It is optional and may return nil. With dryRun, as for
+	// a plan, it must not generate or save anything: secrets that do not
+	// exist yet are left as placeholders.
+	CloudInit func(cfg *Config, profile Profile, dryRun bool) (*CloudConfig, error)
+	// ServerInfo describes the server, such as its public key, at the end
+	// of deploy. It is optional.
+	ServerInfo func(cfg *Config, profile Profile) (string, error)
+	// Peers manages the VPN clients, the peers commands are only offered
+	// when it is set
+	Peers PeerManager
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// MaxQRCodeSize is the most bytes a single QR code holds, version 40 with
+// low error recovery
+const MaxQRCodeSize = 2953
+
+// qrCodePixels is the size of a QR module in the PNG
+const qrCodePixels = 8
+
+// QRCodeTooLarge reports whether a client profile does not fit in one QR
+// code, in which case the file has to be imported instead
+func (p *ClientProfile) QRCodeTooLarge() bool {
+	return len(p.Content) > MaxQRCodeSize
+}
+
+// QRCodePath returns the PNG written next to the profile
+func (p *ClientProfile) QRCodePath() string {
+	return strings.TrimSuffix(p.Path, filepath.Ext(p.Path)) + ".png"
+}
+
+func (p *ClientProfile) qrCode() (*qrcode.QRCode, error) {
+	if p.QRCodeTooLarge() {
+		return nil, fmt.Errorf("profile %s is %d bytes, more than the %d a single QR code holds, import %s instead", p.Name, len(p.Content), MaxQRCodeSize, p.Path)
+	}
+	code, err := qrcode.New(string(p.Content), qrcode.Low)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode profile %s as a QR code: %v", p.Name, err)
+	}
+	return code, nil
+}
+
+// QRCodeTerminal renders the profile as a QR code for the terminal. Each
+// character is a half block coloured with ANSI codes for two modules, so
+// the code stays square and scans on dark and light themes alike.
+func (p *ClientProfile) QRCodeTerminal() (string, error) {
+	code, err := p.qrCode()
+	if err != nil {
+		return "", err
+	}
+	bitmap := code.Bitmap()
+	color := func(dark bool, light, black int) int {
+		if dark {
+			return black
+		}
+		return light
+	}
+
+	var b strings.Builder
+	for y := 0; y < len(bitmap); y += 2 {
+		last := ""
+		for x := range bitmap[y] {
+			bottom := false
+			if y+1 < len(bitmap) {
+				bottom = bitmap[y+1][x]
+			}
+			if ansi := fmt.Sprintf("\x1b[%d;%dm", color(bitmap[y][x], 97, 30), color(bottom, 107, 40)); ansi != last {
+				b.WriteString(ansi)
+				last = ansi
+			}
+			b.WriteString("▀")
+		}
+		b.WriteString("\x1b[0m\n")
+	}
+	return b.String(), nil
+}
+
+// WriteQRCodePNG writes the profile as a QR code PNG next to the profile and
+// returns its path. Like the profile it holds the client's private key and
+// is only readable by the owner.
+func (p *ClientProfile) WriteQRCodePNG() (string, error) {
+	code, err := p.qrCode()
+	if err != nil {
+		return "", err
+	}
+	png, err := code.PNG(-qrCodePixels)
+	if err != nil {
+		return "", fmt.Errorf("failed to render QR code of profile %s: %v", p.Name, err)
+	}
+	path := p.QRCodePath()
+	if err := os.WriteFile(path, png, 0600); err != nil {
+		return "", fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return path, nil
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+// RuleConflicts checks the declared rules against the rules live in the NSG
+// and describes every live rule of another name that holds a priority a
+// declared rule needs. Azure would reject the declared rule halfway through
+// a deployment otherwise.
+func RuleConflicts(declared []armnetwork.SecurityRule, live []*armnetwork.SecurityRule) []string {
+	var problems []string
+	for _, rule := range declared {
+		props := rule.Properties
+		for _, other := range live {
+			if other == nil || other.Name == nil || other.Properties == nil || other.Properties.Priority == nil || other.Properties.Direction == nil {
+				continue
+			}
+			if strings.EqualFold(*other.Name, *rule.Name) {
+				continue
+			}
+			if *other.Properties.Priority == *props.Priority && strings.EqualFold(string(*other.Properties.Direction), string(*props.Direction)) {
+				problems = append(problems, fmt.Sprintf("rule %q needs %s priority %d, which is taken by rule %q already in the NSG",
+					*rule.Name, strings.ToLower(string(*props.Direction)), *props.Priority, *other.Name))
+			}
+		}
+	}
+	return problems
+}
+
+// findRule returns the rule called name, ignoring case, or nil
+func findRule(rules []*armnetwork.SecurityRule, name string) *armnetwork.SecurityRule {
+	for _, rule := range rules {
+		if rule != nil && rule.Name != nil && strings.EqualFold(*rule.Name, name) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// SameRule reports whether two rules have the same settings, ignoring read
+// only fields such as the provisioning state and eTag
+func SameRule(a, b armnetwork.SecurityRule) bool {
+	return len(ChangedFields(a, b)) == 0
+}
+
+// FieldChange is a setting that differs between the live and declared rule
+type FieldChange struct {
+	Field    string `json:"field"`
+	Live     string `json:"live"`
+	Declared string `json:"declared"`
+}
+
+// ChangedFields lists the settings of the declared rule that differ from the
+// live one
+func ChangedFields(live, declared armnetwork.SecurityRule) []FieldChange {
+	liveFields, declaredFields := ruleFields(live), ruleFields(declared)
+	var changes []FieldChange
+	for i := range liveFields {
+		if !strings.EqualFold(liveFields[i].value, declaredFields[i].value) {
+			changes = append(changes, FieldChange{Field: liveFields[i].name, Live: liveFields[i].value, Declared: declaredFields[i].value})
+		}
+	}
+	return changes
+}
+
+type ruleField struct {
+	name, value string
+}
+
+// ruleFields flattens the settings of a rule into comparable strings. The
+// single and list forms of ports and prefixes are merged and sorted, as the
+// order does not matter to Azure.
+func ruleFields(rule armnetwork.SecurityRule) []ruleField {
+	props := rule.Properties
+	if props == nil {
+		props = &armnetwork.SecurityRulePropertiesFormat{}
+	}
+	list := func(single *string, plural []*string) string {
+		values := derefAll(plural)
+		if single != nil && *single != "" {
+			values = append(values, *single)
+		}
+		slices.Sort(values)
+		return strings.Join(values, ",")
+	}
+	priority := ""
+	if props.Priority != nil {
+		priority = fmt.Sprint(*props.Priority)
+	}
+	return []ruleField{
+		{"description", stringValue(props.Description)},
+		{"priority", priority},
+		{"protocol", stringValue(props.Protocol)},
+		{"access", stringValue(props.Access)},
+		{"direction", stringValue(props.Direction)},
+		{"source ports", list(props.SourcePortRange, props.SourcePortRanges)},
+		{"ports", list(props.DestinationPortRange, props.DestinationPortRanges)},
+		{"sources", list(props.SourceAddressPrefix, props.SourceAddressPrefixes)},
+		{"destinations", list(props.DestinationAddressPrefix, props.DestinationAddressPrefixes)},
+	}
+}
+
+// stringValue dereferences a string or SDK enum pointer, nil being ""
+func stringValue[T ~string](v *T) string {
+	if v == nil {
+		return ""
+	}
+	return string(*v)
+}
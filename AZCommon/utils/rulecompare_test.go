@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+func TestRuleConflicts(t *testing.T) {
+	declared := []armnetwork.SecurityRule{
+		testRule("Allow-SSH", DirectionInbound, 100),
+		testRule("Allow-Web", DirectionInbound, 150),
+		testRule("Allow-Out", DirectionOutbound, 100),
+	}
+	tests := []struct {
+		name string
+		live []armnetwork.SecurityRule
+		want []string
+	}{
+		{
+			name: "empty NSG",
+		},
+		{
+			name: "the declared rule itself in another case",
+			live: []armnetwork.SecurityRule{testRule("allow-ssh", DirectionInbound, 100)},
+		},
+		{
+			name: "unmanaged rule on a free priority",
+			live: []armnetwork.SecurityRule{testRule("Manual", DirectionInbound, 200)},
+		},
+		{
+			name: "unmanaged rule on the same priority in the other direction",
+			live: []armnetwork.SecurityRule{testRule("Manual", DirectionOutbound, 150)},
+		},
+		{
+			name: "unmanaged rule holds a declared priority",
+			live: []armnetwork.SecurityRule{testRule("Manual", DirectionInbound, 150)},
+			want: []string{`rule "Allow-Web" needs inbound priority 150, which is taken by rule "Manual"`},
+		},
+		{
+			name: "rules holding each other's priority",
+			live: []armnetwork.SecurityRule{
+				testRule("Allow-Web", DirectionInbound, 100),
+				testRule("Allow-SSH", DirectionInbound, 150),
+			},
+			want: []string{
+				`rule "Allow-SSH" needs inbound priority 100, which is taken by rule "Allow-Web"`,
+				`rule "Allow-Web" needs inbound priority 150, which is taken by rule "Allow-SSH"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var live []*armnetwork.SecurityRule
+			for i := range tt.live {
+				live = append(live, &tt.live[i])
+			}
+			problems := RuleConflicts(declared, live)
+			if len(problems) != len(tt.want) {
+				t.Fatalf("got %d problems, want %d:\n%s", len(problems), len(tt.want), strings.Join(problems, "\n"))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(problems[i], want) {
+					t.Errorf("problem %d = %q, want it to contain %q", i, problems[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,497 @@
+package utils
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+// Values accepted by the fields of a RuleSpec, matched case-insensitively
+const (
+	DirectionInbound  = "Inbound"
+	DirectionOutbound = "Outbound"
+
+	ProtocolTCP  = "TCP"
+	ProtocolUDP  = "UDP"
+	ProtocolICMP = "ICMP"
+	ProtocolAny  = "Any"
+
+	AccessAllow = "Allow"
+	AccessDeny  = "Deny"
+)
+
+// Priorities Azure accepts for security rules
+const (
+	MinRulePriority = 100
+	MaxRulePriority = 4096
+)
+
+// Priorities given to rules without an explicit one, see allocatePriorities
+const (
+	firstRulePriority = 100
+	rulePriorityStep  = 50
+)
+
+// ManagedRuleMarker ends the description of every rule created from a
+// RuleSpec. Rules without it were added by hand and are never deleted.
+const ManagedRuleMarker = "[azcommon]"
+
+// anyAddress and anyPort match everything in a security rule
+const (
+	anyAddress = "*"
+	anyPort    = "*"
+)
+
+// RuleSpec declares one NSG security rule. Only Name and Protocol are
+// required, the rest defaults to an inbound Allow rule for every port from
+// anywhere to anywhere.
+type RuleSpec struct {
+	Name string `json:"name" yaml:"name"`
+	// Direction is Inbound or Outbound
+	Direction string `json:"direction,omitempty" yaml:"direction,omitempty"`
+	// Protocol is TCP, UDP, ICMP or Any
+	Protocol string `json:"protocol" yaml:"protocol"`
+	// Ports is a port ("22"), a range ("8000-8100"), a comma separated list
+	// of both ("80,443,8000-8100") or "*"
+	Ports string `json:"ports,omitempty" yaml:"ports,omitempty"`
+	// Sources are CIDRs, IP addresses, "*" or a single service tag such as
+	// "Internet" or "AzureLoadBalancer"
+	Sources []string `json:"sources,omitempty" yaml:"sources,omitempty"`
+	// Destination is a CIDR, IP address, service tag or "*"
+	Destination string `json:"destination,omitempty" yaml:"destination,omitempty"`
+	// Access is Allow or Deny
+	Access string `json:"access,omitempty" yaml:"access,omitempty"`
+	// Priority between 100 and 4096, lower wins. Left at 0, the rule gets
+	// the next free one of 100, 150, 200 and so on in its direction, in the
+	// order the rules are declared.
+	Priority int32 `json:"priority,omitempty" yaml:"priority,omitempty"`
+}
+
+// DefaultRuleSpecs are the rules deployed when the config declares none: the
+// profile's VPN port, SSH, HTTP and HTTPS, open to everyone
+func DefaultRuleSpecs(profile Profile) []RuleSpec {
+	open := func(name, protocol string, port int) RuleSpec {
+		return RuleSpec{
+			Name:        name,
+			Direction:   DirectionInbound,
+			Protocol:    protocol,
+			Ports:       strconv.Itoa(port),
+			Sources:     []string{"0.0.0.0/0"},
+			Destination: "0.0.0.0/0",
+			Access:      AccessAllow,
+		}
+	}
+	return []RuleSpec{
+		open(profile.RuleName, profile.VPNProtocol, profile.VPNPort),
+		open("Allow-Port-SSH", ProtocolTCP, 22),
+		open("Allow-Port-HTTP", ProtocolTCP, 80),
+		open("Allow-Port-HTTPS", ProtocolTCP, 443),
+	}
+}
+
+// RuleSpecs returns the rules declared in the config, or the profile's
+// defaults when there are none, with the management ports locked down to
+// MANAGEMENT_SOURCES when it is set, followed by the outbound and deny-all
+// rules of the egress settings and, for dual stack, the IPv6 twins of rules
+// open to every IPv4 address
+func (c *Config) RuleSpecs(profile Profile) []RuleSpec {
+	specs := c.Rules
+	if len(specs) == 0 {
+		profile.VPNPort = c.VPNPort(profile)
+		specs = DefaultRuleSpecs(profile)
+	}
+	if sources := splitList(c.ManagementSources); len(sources) > 0 {
+		specs = LockDown(specs, profile, c.managementPorts(), sources)
+	}
+	specs = append(specs[:len(specs):len(specs)], c.egressRuleSpecs()...)
+	if c.DualStack() {
+		specs = append(specs, mirrorIPv6(specs)...)
+	}
+	return append(specs, c.denyAllRuleSpecs()...)
+}
+
+// ValidateRules checks the whole rule set RuleSpecs builds, as the egress
+// presets, deny-all rules, lockdown and IPv6 twins can clash with the
+// declared rules even when those are valid on their own. A "myip" source
+// not resolved yet is checked as the single address it resolves to, so this
+// needs no network.
+func (c *Config) ValidateRules(profile Profile) error {
+	cfg := *c
+	if cfg.unresolvedSources() {
+		var sources []string
+		for _, source := range splitList(cfg.ManagementSources) {
+			if strings.EqualFold(source, MyIPSource) {
+				source = unresolvedIPSource
+			}
+			sources = append(sources, source)
+		}
+		cfg.ManagementSources = strings.Join(sources, ",")
+	}
+	if problems := ValidateRuleSpecs(cfg.RuleSpecs(profile)); len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// unresolvedIPSource stands in for "myip" in ValidateRules, an address from
+// the documentation range
+const unresolvedIPSource = "192.0.2.1/32"
+
+// NetSecRules validates the declared rules and builds the security rules
+// CreateNsg creates inline, in declaration order. Each rule carries its name
+// in Name.
+func NetSecRules(cfg *Config, profile Profile) ([]armnetwork.SecurityRule, error) {
+	if cfg.unresolvedSources() {
+		return nil, fmt.Errorf("MANAGEMENT_SOURCES uses %s, which has to be resolved first", MyIPSource)
+	}
+	specs := cfg.RuleSpecs(profile)
+	if problems := ValidateRuleSpecs(specs); len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+
+	var rules []armnetwork.SecurityRule
+	for _, spec := range resolveRuleSpecs(specs) {
+		rules = append(rules, spec.securityRule())
+	}
+	return rules, nil
+}
+
+// resolvedRule is a RuleSpec with its defaults applied and values parsed
+type resolvedRule struct {
+	RuleSpec
+	ports   []portRange
+	sources []address
+	dest    address
+}
+
+type portRange struct{ from, to int }
+
+// address is a parsed source or destination: any, a prefix or a service tag
+type address struct {
+	any    bool
+	prefix netip.Prefix
+	tag    string
+}
+
+// resolveRuleSpecs applies the defaults to every spec and normalizes the
+// case of enumerated values. The specs must be valid.
+func resolveRuleSpecs(specs []RuleSpec) []resolvedRule {
+	resolved := make([]resolvedRule, len(specs))
+	for i, spec := range specs {
+		r := resolvedRule{RuleSpec: spec}
+		r.Direction, _ = lookupFold([]string{DirectionInbound, DirectionOutbound}, orDefault(spec.Direction, DirectionInbound))
+		r.Protocol, _ = lookupFold(protocolValues(), spec.Protocol)
+		r.Access, _ = lookupFold([]string{AccessAllow, AccessDeny}, orDefault(spec.Access, AccessAllow))
+		r.Ports = orDefault(spec.Ports, anyPort)
+		if len(r.Sources) == 0 {
+			r.Sources = []string{anyAddress}
+		}
+		r.Destination = orDefault(spec.Destination, anyAddress)
+
+		r.ports, _ = parsePorts(r.Ports)
+		for _, source := range r.Sources {
+			a, _ := parseAddress(source)
+			r.sources = append(r.sources, a)
+		}
+		r.dest, _ = parseAddress(r.Destination)
+		resolved[i] = r
+	}
+	allocatePriorities(resolved)
+	return resolved
+}
+
+// allocatePriorities gives every rule without an explicit priority the next
+// free slot of its direction, 100, 150, 200 and so on in declaration order,
+// skipping the priorities other rules ask for. The same rules therefore always
+// get the same priorities, and adding a rule at the end does not move the
+// others.
+func allocatePriorities(rules []resolvedRule) {
+	taken := map[string]map[int32]bool{}
+	next := map[string]int32{}
+	for _, r := range rules {
+		if taken[r.Direction] == nil {
+			taken[r.Direction] = map[int32]bool{}
+			next[r.Direction] = firstRulePriority
+		}
+		if r.Priority != 0 {
+			taken[r.Direction][r.Priority] = true
+		}
+	}
+	for i := range rules {
+		r := &rules[i]
+		if r.Priority != 0 {
+			continue
+		}
+		priority := next[r.Direction]
+		for taken[r.Direction][priority] {
+			priority += rulePriorityStep
+		}
+		r.Priority = priority
+		taken[r.Direction][priority] = true
+		next[r.Direction] = priority + rulePriorityStep
+	}
+}
+
+func orDefault(value, fallback string) string {
+	if strings.TrimSpace(value) == "" {
+		return fallback
+	}
+	return strings.TrimSpace(value)
+}
+
+func protocolValues() []string {
+	return []string{ProtocolTCP, ProtocolUDP, ProtocolICMP, ProtocolAny}
+}
+
+// IsManagedRule reports whether a live rule was created from a RuleSpec
+func IsManagedRule(rule armnetwork.SecurityRule) bool {
+	return rule.Properties != nil && strings.HasSuffix(stringValue(rule.Properties.Description), ManagedRuleMarker)
+}
+
+// securityRule builds the request body of a resolved rule
+func (r resolvedRule) securityRule() armnetwork.SecurityRule {
+	protocol := armnetwork.SecurityRuleProtocol(r.Protocol)
+	switch r.Protocol {
+	case ProtocolTCP:
+		protocol = armnetwork.SecurityRuleProtocolTCP
+	case ProtocolUDP:
+		protocol = armnetwork.SecurityRuleProtocolUDP
+	case ProtocolICMP:
+		protocol = armnetwork.SecurityRuleProtocolIcmp
+	case ProtocolAny:
+		protocol = armnetwork.SecurityRuleProtocolAsterisk
+	}
+
+	portWord := "port"
+	if r.Ports == anyPort || len(r.ports) > 1 || r.ports[0].from != r.ports[0].to {
+		portWord = "ports"
+	}
+	props := &armnetwork.SecurityRulePropertiesFormat{
+		Description:              to.Ptr(fmt.Sprintf("%s %s traffic on %s %s %s", r.Access, strings.ToLower(r.Direction), portWord, r.Ports, ManagedRuleMarker)),
+		Protocol:                 to.Ptr(protocol),
+		SourcePortRange:          to.Ptr(anyPort),
+		DestinationAddressPrefix: to.Ptr(r.Destination),
+		Access:                   to.Ptr(armnetwork.SecurityRuleAccess(r.Access)),
+		Priority:                 to.Ptr(r.Priority),
+		Direction:                to.Ptr(armnetwork.SecurityRuleDirection(r.Direction)),
+	}
+
+	// Azure takes a single value or a list, not both
+	ports := splitList(r.Ports)
+	if len(ports) == 1 {
+		props.DestinationPortRange = to.Ptr(ports[0])
+	} else {
+		props.DestinationPortRanges = to.SliceOfPtrs(ports...)
+	}
+	if len(r.Sources) == 1 {
+		props.SourceAddressPrefix = to.Ptr(r.Sources[0])
+	} else {
+		props.SourceAddressPrefixes = to.SliceOfPtrs(r.Sources...)
+	}
+
+	return armnetwork.SecurityRule{
+		Name:       to.Ptr(r.Name),
+		Properties: props,
+	}
+}
+
+var (
+	ruleNamePattern   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9_.-]{0,78}[A-Za-z0-9_])?$`)
+	serviceTagPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(\.[A-Za-z0-9]+)?$`)
+)
+
+// ValidateRuleSpecs checks every rule on its own, then looks for priority
+// collisions and overlapping rules within each direction. Overlapping rules
+// with the same access are reported since one of them is redundant; an Allow
+// overlapping a Deny is how exceptions are written and is left to priority.
+func ValidateRuleSpecs(specs []RuleSpec) []string {
+	var problems []string
+	names := map[string]bool{}
+	for i, spec := range specs {
+		label := fmt.Sprintf("rule %d", i+1)
+		if spec.Name != "" {
+			label = fmt.Sprintf("rule %q", spec.Name)
+		}
+		problems = append(problems, spec.validate(label)...)
+		key := strings.ToLower(spec.Name)
+		if spec.Name != "" && names[key] {
+			problems = append(problems, fmt.Sprintf("%s is declared more than once", label))
+		}
+		names[key] = true
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+
+	rules := resolveRuleSpecs(specs)
+	for _, r := range rules {
+		if r.Priority > MaxRulePriority {
+			problems = append(problems, fmt.Sprintf("rule %q would get priority %d, above %d, give it an explicit priority", r.Name, r.Priority, MaxRulePriority))
+		}
+	}
+	for i, a := range rules {
+		for _, b := range rules[i+1:] {
+			if a.Direction != b.Direction {
+				continue
+			}
+			if a.Priority == b.Priority {
+				problems = append(problems, fmt.Sprintf("rules %q and %q both have %s priority %d", a.Name, b.Name, strings.ToLower(a.Direction), a.Priority))
+			}
+			if a.Access == b.Access && a.overlaps(b) {
+				problems = append(problems, fmt.Sprintf("rules %q and %q overlap, both %s some of the same %s traffic", a.Name, b.Name, strings.ToLower(a.Access), strings.ToLower(a.Direction)))
+			}
+		}
+	}
+	return problems
+}
+
+// validate checks the fields of a single rule
+func (s RuleSpec) validate(label string) []string {
+	var problems []string
+	if !ruleNamePattern.MatchString(s.Name) {
+		problems = append(problems, fmt.Sprintf("%s needs a name of 1 to 80 letters, digits, '.', '-' or '_'", label))
+	}
+	if _, ok := lookupFold([]string{DirectionInbound, DirectionOutbound}, orDefault(s.Direction, DirectionInbound)); !ok {
+		problems = append(problems, fmt.Sprintf("%s has direction %q, expected Inbound or Outbound", label, s.Direction))
+	}
+	protocol, ok := lookupFold(protocolValues(), s.Protocol)
+	if !ok {
+		problems = append(problems, fmt.Sprintf("%s has protocol %q, expected one of %s", label, s.Protocol, strings.Join(protocolValues(), ", ")))
+	}
+	if _, ok := lookupFold([]string{AccessAllow, AccessDeny}, orDefault(s.Access, AccessAllow)); !ok {
+		problems = append(problems, fmt.Sprintf("%s has access %q, expected Allow or Deny", label, s.Access))
+	}
+	if s.Priority != 0 && (s.Priority < MinRulePriority || s.Priority > MaxRulePriority) {
+		problems = append(problems, fmt.Sprintf("%s has priority %d, expected %d to %d", label, s.Priority, MinRulePriority, MaxRulePriority))
+	}
+
+	ports := orDefault(s.Ports, anyPort)
+	if _, err := parsePorts(ports); err != nil {
+		problems = append(problems, fmt.Sprintf("%s: %v", label, err))
+	} else if protocol == ProtocolICMP && ports != anyPort {
+		problems = append(problems, fmt.Sprintf("%s uses ICMP, which has no ports, leave ports empty or \"*\"", label))
+	}
+
+	sources := s.Sources
+	if len(sources) == 0 {
+		sources = []string{anyAddress}
+	}
+	for _, source := range sources {
+		a, err := parseAddress(source)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s source: %v", label, err))
+		} else if len(sources) > 1 && !a.isPrefix() {
+			// Azure only accepts IP prefixes in a list
+			problems = append(problems, fmt.Sprintf("%s source %q must be the only source", label, source))
+		}
+	}
+	if _, err := parseAddress(orDefault(s.Destination, anyAddress)); err != nil {
+		problems = append(problems, fmt.Sprintf("%s destination: %v", label, err))
+	}
+	return problems
+}
+
+// parsePorts parses "*" or a comma separated list of ports and ranges
+func parsePorts(value string) ([]portRange, error) {
+	if value == anyPort {
+		return []portRange{{1, 65535}}, nil
+	}
+	items := splitList(value)
+	if len(items) == 0 {
+		return nil, fmt.Errorf("no ports in %q", value)
+	}
+	var ranges []portRange
+	for _, item := range items {
+		from, until, isRange := strings.Cut(item, "-")
+		if !isRange {
+			until = from
+		}
+		first, err1 := strconv.Atoi(strings.TrimSpace(from))
+		last, err2 := strconv.Atoi(strings.TrimSpace(until))
+		if err1 != nil || err2 != nil || first < 1 || last > 65535 || first > last {
+			return nil, fmt.Errorf("%q is not a port or port range between 1 and 65535", item)
+		}
+		ranges = append(ranges, portRange{first, last})
+	}
+	return ranges, nil
+}
+
+// parseAddress parses "*", an IP address, a CIDR or a service tag
+func parseAddress(value string) (address, error) {
+	value = strings.TrimSpace(value)
+	if value == anyAddress {
+		return address{any: true}, nil
+	}
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return address{prefix: netip.PrefixFrom(addr, addr.BitLen())}, nil
+	}
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return address{}, fmt.Errorf("%q is not a valid CIDR", value)
+		}
+		if prefix.Masked() != prefix {
+			return address{}, fmt.Errorf("%q has host bits set, did you mean %s?", value, prefix.Masked())
+		}
+		return address{prefix: prefix}, nil
+	}
+	if !serviceTagPattern.MatchString(value) {
+		return address{}, fmt.Errorf("%q is not an IP address, CIDR or service tag", value)
+	}
+	return address{tag: value}, nil
+}
+
+func (a address) isPrefix() bool {
+	return a.prefix.IsValid()
+}
+
+// overlaps reports whether two addresses can match the same traffic. A
+// service tag is only known to overlap with itself and with "*".
+func (a address) overlaps(b address) bool {
+	switch {
+	case a.any || b.any:
+		return true
+	case a.isPrefix() && b.isPrefix():
+		return a.prefix.Overlaps(b.prefix)
+	}
+	return a.tag != "" && strings.EqualFold(a.tag, b.tag)
+}
+
+// overlaps reports whether two rules of the same direction can match the
+// same packet
+func (r resolvedRule) overlaps(other resolvedRule) bool {
+	if r.Protocol != other.Protocol && r.Protocol != ProtocolAny && other.Protocol != ProtocolAny {
+		return false
+	}
+	if !r.dest.overlaps(other.dest) {
+		return false
+	}
+	// ICMP has no ports, so only the addresses matter against it
+	if r.Protocol != ProtocolICMP && other.Protocol != ProtocolICMP && !portsOverlap(r.ports, other.ports) {
+		return false
+	}
+	for _, a := range r.sources {
+		for _, b := range other.sources {
+			if a.overlaps(b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func portsOverlap(a, b []portRange) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x.from <= y.to && y.from <= x.to {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,337 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRuleSpecs(t *testing.T) {
+	tests := []struct {
+		name  string
+		specs []RuleSpec
+		// want lists a substring of every expected problem, none means the
+		// specs are accepted
+		want []string
+	}{
+		{
+			name: "adjacent port ranges",
+			specs: []RuleSpec{
+				{Name: "Low", Protocol: "TCP", Ports: "1000-2000"},
+				{Name: "High", Protocol: "TCP", Ports: "2001-3000"},
+			},
+		},
+		{
+			name: "overlapping port ranges",
+			specs: []RuleSpec{
+				{Name: "Range", Protocol: "TCP", Ports: "1000-2000"},
+				{Name: "Single", Protocol: "TCP", Ports: "80,1500"},
+			},
+			want: []string{`rules "Range" and "Single" overlap`},
+		},
+		{
+			name: "same ports over different protocols",
+			specs: []RuleSpec{
+				{Name: "Tcp", Protocol: "TCP", Ports: "53"},
+				{Name: "Udp", Protocol: "UDP", Ports: "53"},
+			},
+		},
+		{
+			name: "any protocol overlaps a port rule",
+			specs: []RuleSpec{
+				{Name: "Ssh", Protocol: "TCP", Ports: "22"},
+				{Name: "All", Protocol: "any", Ports: "1-1024"},
+			},
+			want: []string{`rules "Ssh" and "All" overlap`},
+		},
+		{
+			name: "ICMP without ports",
+			specs: []RuleSpec{
+				{Name: "Ping", Protocol: "icmp"},
+				{Name: "Ssh", Protocol: "TCP", Ports: "22"},
+			},
+		},
+		{
+			name: "ICMP with ports",
+			specs: []RuleSpec{
+				{Name: "Ping", Protocol: "ICMP", Ports: "7"},
+			},
+			want: []string{`rule "Ping" uses ICMP, which has no ports`},
+		},
+		{
+			name: "ICMP overlaps any protocol whatever its ports",
+			specs: []RuleSpec{
+				{Name: "Ping", Protocol: "ICMP"},
+				{Name: "Web", Protocol: "Any", Ports: "443"},
+			},
+			want: []string{`rules "Ping" and "Web" overlap`},
+		},
+		{
+			name: "service tag and CIDR are not known to overlap",
+			specs: []RuleSpec{
+				{Name: "Internet", Protocol: "TCP", Ports: "443", Sources: []string{"Internet"}},
+				{Name: "Office", Protocol: "TCP", Ports: "443", Sources: []string{"203.0.113.0/24"}},
+			},
+		},
+		{
+			name: "same service tag in another case",
+			specs: []RuleSpec{
+				{Name: "Web", Protocol: "TCP", Ports: "443", Sources: []string{"Internet"}},
+				{Name: "Web-Again", Protocol: "TCP", Ports: "443", Sources: []string{"internet"}},
+			},
+			want: []string{`rules "Web" and "Web-Again" overlap`},
+		},
+		{
+			name: "service tag must be the only source",
+			specs: []RuleSpec{
+				{Name: "Mixed", Protocol: "TCP", Ports: "22", Sources: []string{"Internet", "10.0.0.0/8"}},
+			},
+			want: []string{`rule "Mixed" source "Internet" must be the only source`},
+		},
+		{
+			name: "any source overlaps a CIDR",
+			specs: []RuleSpec{
+				{Name: "Office", Protocol: "TCP", Ports: "22", Sources: []string{"203.0.113.0/24"}},
+				{Name: "Everyone", Protocol: "TCP", Ports: "22", Sources: []string{"*"}},
+			},
+			want: []string{`rules "Office" and "Everyone" overlap`},
+		},
+		{
+			name: "any destination overlaps a service tag",
+			specs: []RuleSpec{
+				{Name: "Azure", Direction: "Outbound", Protocol: "TCP", Ports: "443", Destination: "AzureCloud"},
+				{Name: "Https", Direction: "outbound", Protocol: "TCP", Ports: "443"},
+			},
+			want: []string{`rules "Azure" and "Https" overlap`},
+		},
+		{
+			name: "disjoint CIDRs",
+			specs: []RuleSpec{
+				{Name: "Office", Protocol: "TCP", Ports: "22", Sources: []string{"203.0.113.0/25"}},
+				{Name: "Branch", Protocol: "TCP", Ports: "22", Sources: []string{"203.0.113.128/25", "198.51.100.7"}},
+			},
+		},
+		{
+			name: "deny overlapping allow is an exception",
+			specs: []RuleSpec{
+				{Name: "Block", Protocol: "TCP", Ports: "22", Sources: []string{"203.0.113.66"}, Access: "Deny"},
+				{Name: "Allow", Protocol: "TCP", Ports: "22", Sources: []string{"203.0.113.0/24"}},
+			},
+		},
+		{
+			name: "overlap in different directions",
+			specs: []RuleSpec{
+				{Name: "In", Protocol: "Any"},
+				{Name: "Out", Direction: "Outbound", Protocol: "Any"},
+			},
+		},
+		{
+			name: "explicit priority collision",
+			specs: []RuleSpec{
+				{Name: "Ssh", Protocol: "TCP", Ports: "22", Priority: 300},
+				{Name: "Web", Protocol: "TCP", Ports: "443", Priority: 300},
+			},
+			want: []string{`rules "Ssh" and "Web" both have inbound priority 300`},
+		},
+		{
+			name: "invalid values",
+			specs: []RuleSpec{
+				{Name: "Bad", Protocol: "GRE", Ports: "0-22", Sources: []string{"10.0.0.1/8"}, Priority: 50},
+			},
+			want: []string{
+				`has protocol "GRE"`,
+				`has priority 50`,
+				`"0-22" is not a port`,
+				`did you mean 10.0.0.0/8?`,
+			},
+		},
+		{
+			name: "duplicate names",
+			specs: []RuleSpec{
+				{Name: "Ssh", Protocol: "TCP", Ports: "22"},
+				{Name: "SSH", Protocol: "TCP", Ports: "2222"},
+			},
+			want: []string{`rule "SSH" is declared more than once`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := ValidateRuleSpecs(tt.specs)
+			if len(problems) != len(tt.want) {
+				t.Fatalf("got %d problems, want %d:\n%s", len(problems), len(tt.want), strings.Join(problems, "\n"))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(problems[i], want) {
+					t.Errorf("problem %d = %q, want it to contain %q", i, problems[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestAllocatePriorities(t *testing.T) {
+	in, out := DirectionInbound, DirectionOutbound
+	tests := []struct {
+		name       string
+		directions []string
+		priorities []int32
+		want       []int32
+	}{
+		{
+			name:       "declaration order",
+			directions: []string{in, in, in},
+			priorities: []int32{0, 0, 0},
+			want:       []int32{100, 150, 200},
+		},
+		{
+			name:       "each direction counts on its own",
+			directions: []string{in, out, in, out},
+			priorities: []int32{0, 0, 0, 0},
+			want:       []int32{100, 100, 150, 150},
+		},
+		{
+			name:       "explicit priority leaves a gap",
+			directions: []string{in, in, in, in},
+			priorities: []int32{0, 150, 0, 0},
+			want:       []int32{100, 150, 200, 250},
+		},
+		{
+			name:       "explicit priority declared later is still skipped",
+			directions: []string{in, in, in},
+			priorities: []int32{0, 0, 100},
+			want:       []int32{150, 200, 100},
+		},
+		{
+			name:       "explicit priority off the step moves nothing",
+			directions: []string{in, in, in},
+			priorities: []int32{0, 120, 0},
+			want:       []int32{100, 120, 150},
+		},
+		{
+			name:       "explicit priority in the other direction is no gap",
+			directions: []string{out, in, in},
+			priorities: []int32{150, 0, 0},
+			want:       []int32{150, 100, 150},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := make([]resolvedRule, len(tt.directions))
+			for i := range rules {
+				rules[i].Direction = tt.directions[i]
+				rules[i].Priority = tt.priorities[i]
+			}
+			allocatePriorities(rules)
+			for i, r := range rules {
+				if r.Priority != tt.want[i] {
+					t.Errorf("rule %d got priority %d, want %d", i, r.Priority, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestResolveRuleSpecsPrioritiesAreStable(t *testing.T) {
+	specs := []RuleSpec{
+		{Name: "Vpn", Protocol: "UDP", Ports: "51820"},
+		{Name: "Ssh", Protocol: "TCP", Ports: "22", Priority: 150},
+		{Name: "Web", Protocol: "TCP", Ports: "443"},
+		{Name: "Out", Direction: "outbound", Protocol: "TCP", Ports: "443"},
+	}
+	priorities := func(specs []RuleSpec) map[string]int32 {
+		got := map[string]int32{}
+		for _, r := range resolveRuleSpecs(specs) {
+			got[r.Name] = r.Priority
+		}
+		return got
+	}
+
+	first := priorities(specs)
+	want := map[string]int32{"Vpn": 100, "Ssh": 150, "Web": 200, "Out": 100}
+	for name, priority := range want {
+		if first[name] != priority {
+			t.Errorf("rule %s got priority %d, want %d", name, first[name], priority)
+		}
+	}
+	for run := 0; run < 3; run++ {
+		for name, priority := range priorities(specs) {
+			if first[name] != priority {
+				t.Fatalf("run %d moved rule %s from %d to %d", run+2, name, first[name], priority)
+			}
+		}
+	}
+
+	// A rule added at the end takes the next slot and leaves the others alone
+	grown := priorities(append(specs[:len(specs):len(specs)], RuleSpec{Name: "Dns", Protocol: "UDP", Ports: "53"}))
+	for name, priority := range first {
+		if grown[name] != priority {
+			t.Errorf("adding a rule moved %s from %d to %d", name, priority, grown[name])
+		}
+	}
+	if grown["Dns"] != 250 {
+		t.Errorf("added rule got priority %d, want 250", grown["Dns"])
+	}
+}
+
+func TestValidateRulesChecksGeneratedRules(t *testing.T) {
+	profile := Profile{RuleName: "Allow-VPN", VPNProtocol: ProtocolUDP, VPNPort: 51820}
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{
+			name: "declared rule named like an egress preset",
+			cfg: Config{
+				Rules:       []RuleSpec{{Name: "Allow-HTTPS-Out", Direction: "Outbound", Protocol: "TCP", Ports: "8443"}},
+				EgressAllow: EgressHTTPS,
+			},
+			want: `rule "Allow-HTTPS-Out" is declared more than once`,
+		},
+		{
+			name: "declared rule at the deny-all priority",
+			cfg: Config{
+				Rules:       []RuleSpec{{Name: "Allow-SSH", Protocol: "TCP", Ports: "22", Priority: 4096}},
+				DefaultDeny: DenyInbound,
+			},
+			want: `rules "Allow-SSH" and "Deny-All-Inbound" both have inbound priority 4096`,
+		},
+		{
+			name: "declared rule named like an IPv6 twin",
+			cfg: Config{
+				Rules: []RuleSpec{
+					{Name: "X", Protocol: "TCP", Ports: "22", Sources: []string{"0.0.0.0/0"}},
+					{Name: "X-IPv6", Protocol: "TCP", Ports: "2222", Sources: []string{"::/0"}},
+				},
+				AddressPrefixV6: "fd00::/48",
+			},
+			want: `rule "X-IPv6" is declared more than once`,
+		},
+		{
+			name: "unresolved myip source",
+			cfg: Config{
+				Rules:             []RuleSpec{{Name: "Allow-SSH", Protocol: "TCP", Ports: "22"}},
+				ManagementSources: "myip",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if problems := ValidateRuleSpecs(tt.cfg.Rules); len(problems) > 0 {
+				t.Fatalf("declared rules should be valid on their own: %v", problems)
+			}
+			err := tt.cfg.ValidateRules(profile)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("got %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
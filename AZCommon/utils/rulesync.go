@@ -0,0 +1,304 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+// Actions of a RuleChange
+const (
+	RuleAdded     = "add"
+	RuleChanged   = "change"
+	RuleRemoved   = "remove"
+	RuleUnmanaged = "unmanaged"
+)
+
+// RuleChange is a difference between the declared and the live rules.
+// Removed rules were created by this tool and are no longer declared;
+// unmanaged ones were added by hand and are only reported.
+type RuleChange struct {
+	Name     string                   `json:"name"`
+	Action   string                   `json:"action"`
+	Declared *armnetwork.SecurityRule `json:"declared,omitempty"`
+	Live     *armnetwork.SecurityRule `json:"live,omitempty"`
+	Fields   []FieldChange            `json:"fields,omitempty"`
+}
+
+// DiffRules compares the declared rules with the live ones. Declared rules
+// come first in declaration order, then live rules that are not declared by
+// priority.
+func DiffRules(declared []armnetwork.SecurityRule, live []*armnetwork.SecurityRule) []RuleChange {
+	var changes []RuleChange
+	for i := range declared {
+		rule := &declared[i]
+		existing := findRule(live, *rule.Name)
+		switch {
+		case existing == nil:
+			changes = append(changes, RuleChange{Name: *rule.Name, Action: RuleAdded, Declared: rule})
+		default:
+			if fields := ChangedFields(*existing, *rule); len(fields) > 0 {
+				changes = append(changes, RuleChange{Name: *rule.Name, Action: RuleChanged, Declared: rule, Live: existing, Fields: fields})
+			}
+		}
+	}
+
+	var extra []*armnetwork.SecurityRule
+	for _, rule := range live {
+		if rule == nil || rule.Name == nil {
+			continue
+		}
+		if !declaresRule(declared, *rule.Name) {
+			extra = append(extra, rule)
+		}
+	}
+	sort.SliceStable(extra, func(i, j int) bool {
+		return rulePriority(extra[i]) < rulePriority(extra[j])
+	})
+	for _, rule := range extra {
+		action := RuleUnmanaged
+		if IsManagedRule(*rule) {
+			action = RuleRemoved
+		}
+		changes = append(changes, RuleChange{Name: *rule.Name, Action: action, Live: rule})
+	}
+	return changes
+}
+
+func declaresRule(declared []armnetwork.SecurityRule, name string) bool {
+	for _, rule := range declared {
+		if strings.EqualFold(*rule.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func rulePriority(rule *armnetwork.SecurityRule) int32 {
+	if rule.Properties == nil || rule.Properties.Priority == nil {
+		return 0
+	}
+	return *rule.Properties.Priority
+}
+
+// PendingRuleChanges reports whether sync has anything to do
+func PendingRuleChanges(changes []RuleChange) bool {
+	for _, change := range changes {
+		if change.Action != RuleUnmanaged {
+			return true
+		}
+	}
+	return false
+}
+
+// DiffNetSecRules compares the declared rules with the rules live in the NSG
+func DiffNetSecRules(ctx context.Context, cred azcore.TokenCredential, cfg *Config, profile Profile) ([]RuleChange, error) {
+	declared, err := NetSecRules(cfg, profile)
+	if err != nil {
+		return nil, err
+	}
+	live, err := ListNetSecRules(ctx, cred, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return DiffRules(declared, live), nil
+}
+
+// SyncNetSecRules applies the changes of a diff, deleting the managed rules
+// that are no longer declared
+func SyncNetSecRules(ctx context.Context, cred azcore.TokenCredential, cfg *Config, changes []RuleChange) error {
+	return applyRuleChanges(ctx, cred, cfg, changes, true)
+}
+
+// applyRuleChanges writes the new and changed rules of a diff. With prune,
+// managed rules that are no longer declared are deleted first, freeing their
+// priorities. Rules left in place must not hold a priority a declared rule
+// needs, that is checked before anything is sent.
+func applyRuleChanges(ctx context.Context, cred azcore.TokenCredential, cfg *Config, changes []RuleChange, prune bool) error {
+	var writes []RuleChange
+	var declared []armnetwork.SecurityRule
+	var fixed []*armnetwork.SecurityRule
+	for _, change := range changes {
+		switch {
+		case change.Action == RuleAdded || change.Action == RuleChanged:
+			writes = append(writes, change)
+			declared = append(declared, *change.Declared)
+		case change.Action == RuleUnmanaged || (change.Action == RuleRemoved && !prune):
+			fixed = append(fixed, change.Live)
+		}
+	}
+	if problems := RuleConflicts(declared, fixed); len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	if prune {
+		for _, change := range changes {
+			if change.Action != RuleRemoved {
+				continue
+			}
+			ref := ResourceRef{Type: TypeSecurityRule, Name: change.Name, Parent: cfg.NSGName}
+			if err := DeleteResource(ctx, cred, cfg, ref); err != nil {
+				return err
+			}
+		}
+	}
+	if len(writes) == 0 {
+		return nil
+	}
+
+	sequence, err := orderRuleWrites(writes, fixed)
+	if err != nil {
+		return err
+	}
+	securityRulesClient, err := armnetwork.NewSecurityRulesClient(cfg.SubscriptionID, cred, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create security rules client: %v", err)
+	}
+	for _, rule := range sequence {
+		if _, err := putRule(ctx, securityRulesClient, cfg, rule); err != nil {
+			return err
+		}
+		InfoLogger.Printf("Rule %s written with priority %d", *rule.Name, *rule.Properties.Priority)
+	}
+	return nil
+}
+
+// orderRuleWrites returns the rules to write, in an order Azure accepts.
+// A rule can only move to a priority nobody holds, so each rule is written
+// once its target is free. Rules waiting on each other, such as two rules
+// swapping priorities, are first parked on a free priority, so a rule can
+// appear twice.
+func orderRuleWrites(writes []RuleChange, fixed []*armnetwork.SecurityRule) ([]armnetwork.SecurityRule, error) {
+	occupied := map[ruleSlot]string{}
+	for _, rule := range fixed {
+		occupied[slotOf(*rule)] = strings.ToLower(*rule.Name)
+	}
+	for _, change := range writes {
+		if change.Live != nil {
+			occupied[slotOf(*change.Live)] = strings.ToLower(change.Name)
+		}
+	}
+
+	var sequence []armnetwork.SecurityRule
+	pending := append([]RuleChange(nil), writes...)
+	for len(pending) > 0 {
+		progressed := false
+		for i := 0; i < len(pending); {
+			change := pending[i]
+			name := strings.ToLower(change.Name)
+			target := slotOf(*change.Declared)
+			if holder, ok := occupied[target]; ok && holder != name {
+				i++
+				continue
+			}
+			sequence = append(sequence, *change.Declared)
+			if change.Live != nil && occupied[slotOf(*change.Live)] == name {
+				delete(occupied, slotOf(*change.Live))
+			}
+			occupied[target] = name
+			pending = append(pending[:i], pending[i+1:]...)
+			progressed = true
+		}
+		if progressed {
+			continue
+		}
+
+		// A cycle always holds a rule that exists already
+		change := &pending[0]
+		for i := range pending {
+			if pending[i].Live != nil {
+				change = &pending[i]
+				break
+			}
+		}
+		parked, err := parkRule(*change.Declared, occupied)
+		if err != nil {
+			return nil, err
+		}
+		sequence = append(sequence, parked)
+		if change.Live != nil {
+			delete(occupied, slotOf(*change.Live))
+		}
+		occupied[slotOf(parked)] = strings.ToLower(change.Name)
+		change.Live = &parked
+	}
+	return sequence, nil
+}
+
+// ruleSlot is a priority in one direction, which only one rule can hold
+type ruleSlot struct {
+	direction string
+	priority  int32
+}
+
+func slotOf(rule armnetwork.SecurityRule) ruleSlot {
+	return ruleSlot{strings.ToLower(stringValue(rule.Properties.Direction)), rulePriority(&rule)}
+}
+
+// parkRule returns a copy of rule at the highest free priority of its
+// direction
+func parkRule(rule armnetwork.SecurityRule, occupied map[ruleSlot]string) (armnetwork.SecurityRule, error) {
+	slot := slotOf(rule)
+	for priority := int32(MaxRulePriority); priority >= MinRulePriority; priority-- {
+		slot.priority = priority
+		if _, ok := occupied[slot]; ok {
+			continue
+		}
+		props := *rule.Properties
+		props.Priority = to.Ptr(priority)
+		rule.Properties = &props
+		return rule, nil
+	}
+	return rule, fmt.Errorf("no free priority to move rule %s to", *rule.Name)
+}
+
+// WriteRuleDiff writes the changes in a diff like format: "+" for rules to
+// add, "~" for rules to change with the fields that differ, "-" for rules to
+// remove and "?" for unmanaged rules sync leaves alone
+func WriteRuleDiff(w io.Writer, changes []RuleChange) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "The NSG rules match the declared rules.")
+		return
+	}
+	for _, change := range changes {
+		switch change.Action {
+		case RuleAdded:
+			fmt.Fprintf(w, "+ %-24s %s\n", change.Name, DescribeRule(*change.Declared))
+		case RuleChanged:
+			fmt.Fprintf(w, "~ %-24s %s\n", change.Name, DescribeRule(*change.Declared))
+			for _, field := range change.Fields {
+				fmt.Fprintf(w, "      %s: %q -> %q\n", field.Field, field.Live, field.Declared)
+			}
+		case RuleRemoved:
+			fmt.Fprintf(w, "- %-24s %s\n", change.Name, DescribeRule(*change.Live))
+		case RuleUnmanaged:
+			fmt.Fprintf(w, "? %-24s %s (not managed, left in place)\n", change.Name, DescribeRule(*change.Live))
+		}
+	}
+}
+
+// putRule creates or updates a single rule in the configured NSG and waits
+// for it to finish
+func putRule(ctx context.Context, client *armnetwork.SecurityRulesClient, cfg *Config, rule armnetwork.SecurityRule) (armnetwork.SecurityRulesClientCreateOrUpdateResponse, error) {
+	ruleName := *rule.Name
+	InfoLogger.Printf("Initiating rule creation for %s", ruleName)
+	rulePoller, err := client.BeginCreateOrUpdate(ctx, cfg.ResourceGroupName, cfg.NSGName, ruleName, rule, nil)
+	if err != nil {
+		ErrorLogger.Printf("Failed to begin creating rule %s: %v", ruleName, err)
+		return armnetwork.SecurityRulesClientCreateOrUpdateResponse{}, fmt.Errorf("failed to begin creating rule %s: %v", ruleName, err)
+	}
+
+	InfoLogger.Printf("Waiting for rule %s creation to complete...", ruleName)
+	ruleResult, err := rulePoller.PollUntilDone(ctx, nil)
+	if err != nil {
+		ErrorLogger.Printf("Failed to complete rule creation for %s: %v", ruleName, err)
+		return armnetwork.SecurityRulesClientCreateOrUpdateResponse{}, fmt.Errorf("failed to complete rule creation for %s: %v", ruleName, err)
+	}
+	return ruleResult, nil
+}
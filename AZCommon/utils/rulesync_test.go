@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/network/armnetwork"
+)
+
+// testRule returns a rule with only the fields that decide its slot
+func testRule(name, direction string, priority int32) armnetwork.SecurityRule {
+	return armnetwork.SecurityRule{
+		Name: to.Ptr(name),
+		Properties: &armnetwork.SecurityRulePropertiesFormat{
+			Direction: to.Ptr(armnetwork.SecurityRuleDirection(direction)),
+			Priority:  to.Ptr(priority),
+		},
+	}
+}
+
+// move describes a write of a rule from a live priority, 0 when it is new,
+// to a declared one
+type move struct {
+	name     string
+	from, to int32
+}
+
+func TestOrderRuleWrites(t *testing.T) {
+	tests := []struct {
+		name  string
+		moves []move
+		// fixed are inbound rules left in place, by name and priority
+		fixed map[string]int32
+		// want lists the writes as name@priority, in order
+		want []string
+	}{
+		{
+			name:  "new rules in declaration order",
+			moves: []move{{"A", 0, 100}, {"B", 0, 150}},
+			want:  []string{"A@100", "B@150"},
+		},
+		{
+			name:  "rule waits for the priority another one leaves",
+			moves: []move{{"A", 100, 150}, {"B", 150, 200}},
+			want:  []string{"B@200", "A@150"},
+		},
+		{
+			name:  "new rule waits for a moving one",
+			moves: []move{{"New", 0, 100}, {"Old", 100, 300}},
+			want:  []string{"Old@300", "New@100"},
+		},
+		{
+			name:  "swap parks one rule",
+			moves: []move{{"A", 100, 150}, {"B", 150, 100}},
+			want:  []string{"A@4096", "B@100", "A@150"},
+		},
+		{
+			name:  "rotation of three parks one rule",
+			moves: []move{{"A", 100, 150}, {"B", 150, 200}, {"C", 200, 100}},
+			want:  []string{"A@4096", "C@100", "B@200", "A@150"},
+		},
+		{
+			name:  "parking skips priorities of unmanaged rules",
+			moves: []move{{"A", 100, 150}, {"B", 150, 100}},
+			fixed: map[string]int32{"Manual": 4096},
+			want:  []string{"A@4095", "B@100", "A@150"},
+		},
+		{
+			name:  "unmanaged rule at another priority is left alone",
+			moves: []move{{"A", 0, 100}},
+			fixed: map[string]int32{"Manual": 200},
+			want:  []string{"A@100"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var writes []RuleChange
+			for _, m := range tt.moves {
+				declared := testRule(m.name, DirectionInbound, m.to)
+				change := RuleChange{Name: m.name, Action: RuleAdded, Declared: &declared}
+				if m.from != 0 {
+					live := testRule(m.name, DirectionInbound, m.from)
+					change.Action, change.Live = RuleChanged, &live
+				}
+				writes = append(writes, change)
+			}
+			var fixed []*armnetwork.SecurityRule
+			for name, priority := range tt.fixed {
+				rule := testRule(name, DirectionInbound, priority)
+				fixed = append(fixed, &rule)
+			}
+
+			sequence, err := orderRuleWrites(writes, fixed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, rule := range sequence {
+				got = append(got, fmt.Sprintf("%s@%d", *rule.Name, *rule.Properties.Priority))
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("got writes %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParkRuleFull(t *testing.T) {
+	occupied := map[ruleSlot]string{}
+	for priority := int32(MinRulePriority); priority <= MaxRulePriority; priority++ {
+		occupied[ruleSlot{"inbound", priority}] = fmt.Sprint("rule", priority)
+	}
+	if _, err := parkRule(testRule("A", DirectionInbound, 100), occupied); err == nil {
+		t.Error("parked a rule in a full direction")
+	}
+	// The other direction is still free
+	parked, err := parkRule(testRule("A", DirectionOutbound, 100), occupied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *parked.Properties.Priority != MaxRulePriority {
+		t.Errorf("parked at %d, want %d", *parked.Properties.Priority, MaxRulePriority)
+	}
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/compute/armcompute/v6"
+)
+
+// runCommandDone is echoed by a script that ran to completion. Run Command
+// reports success even when the script fails, so its output is checked for
+// it instead.
+const runCommandDone = "azcommon: done"
+
+// RunShellScript runs a bash script as root on the VM through Run Command and
+// returns its output. The script stops at the first failing command.
+func RunShellScript(ctx context.Context, cred azcore.TokenCredential, cfg *Config, script string) (string, error) {
+	vmClient, err := armcompute.NewVirtualMachinesClient(cfg.SubscriptionID, cred, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create VM client: %v", err)
+	}
+
+	lines := append([]string{"set -e"}, strings.Split(strings.TrimRight(script, "\n"), "\n")...)
+	lines = append(lines, "echo '"+runCommandDone+"'")
+	InfoLogger.Printf("Running a %d line script on VM %s", len(lines), cfg.VMName)
+	poller, err := vmClient.BeginRunCommand(ctx, cfg.ResourceGroupName, cfg.VMName, armcompute.RunCommandInput{
+		CommandID: to.Ptr("RunShellScript"),
+		Script:    to.SliceOfPtrs(lines...),
+	}, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to run command on VM %s: %v", cfg.VMName, err)
+	}
+	result, err := poller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{
+		Frequency: 5 * time.Second,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to run command on VM %s: %v", cfg.VMName, err)
+	}
+
+	var output []string
+	for _, status := range result.Value {
+		if status != nil && status.Message != nil {
+			output = append(output, *status.Message)
+		}
+	}
+	message := strings.Join(output, "\n")
+	InfoLogger.Printf("Command output: %s", message)
+	if !strings.Contains(message, runCommandDone) {
+		return message, fmt.Errorf("command failed on VM %s: %s", cfg.VMName, message)
+	}
+	return strings.ReplaceAll(message, runCommandDone+"\n", ""), nil
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StateDir is where deployment state files are kept, one per resource group
+const StateDir = "state"
+
+// StepState records a completed deployment step
+type StepState struct {
+	ResourceID  string    `json:"resourceId"`
+	InputHash   string    `json:"inputHash"`
+	CompletedAt time.Time `json:"completedAt"`
+}
+
+// DeploymentState tracks which steps of a deployment have completed, so a
+// failed deployment can be resumed from the step that failed
+type DeploymentState struct {
+	ResourceGroup string               `json:"resourceGroup"`
+	Steps         map[string]StepState `json:"steps"`
+	FailedStep    string               `json:"failedStep,omitempty"`
+	LastError     string               `json:"lastError,omitempty"`
+	UpdatedAt     time.Time            `json:"updatedAt"`
+
+	path string
+}
+
+// StatePath returns the state file used for a resource group
+func StatePath(resourceGroupName string) string {
+	return filepath.Join(StateDir, resourceGroupName+".json")
+}
+
+// LoadState reads the state file for a resource group. A missing file yields
+// an empty state.
+func LoadState(resourceGroupName string) (*DeploymentState, error) {
+	state := &DeploymentState{
+		ResourceGroup: resourceGroupName,
+		Steps:         map[string]StepState{},
+		path:          StatePath(resourceGroupName),
+	}
+
+	data, err := os.ReadFile(state.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %v", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %v", state.path, err)
+	}
+	if state.Steps == nil {
+		state.Steps = map[string]StepState{}
+	}
+	return state, nil
+}
+
+// Exists reports whether any step has been recorded
+func (s *DeploymentState) Exists() bool {
+	return len(s.Steps) > 0
+}
+
+// Completed returns the recorded step if it finished with the same input
+func (s *DeploymentState) Completed(step, inputHash string) (StepState, bool) {
+	done, ok := s.Steps[step]
+	if !ok || done.InputHash != inputHash {
+		return StepState{}, false
+	}
+	return done, true
+}
+
+// Record marks a step as completed and saves the state file
+func (s *DeploymentState) Record(step, resourceID, inputHash string) error {
+	s.Steps[step] = StepState{
+		ResourceID:  resourceID,
+		InputHash:   inputHash,
+		CompletedAt: time.Now().UTC(),
+	}
+	if s.FailedStep == step {
+		s.FailedStep = ""
+		s.LastError = ""
+	}
+	return s.Save()
+}
+
+// Fail records the step that failed and saves the state file
+func (s *DeploymentState) Fail(step string, err error) error {
+	s.FailedStep = step
+	s.LastError = err.Error()
+	return s.Save()
+}
+
+// Save writes the state file
+func (s *DeploymentState) Save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %v", err)
+	}
+	s.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	return nil
+}
+
+// Remove deletes the state file, used once the resource group is gone
+func (s *DeploymentState) Remove() error {
+	s.Steps = map[string]StepState{}
+	s.FailedStep = ""
+	s.LastError = ""
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove state file: %v", err)
+	}
+	return nil
+}
+
+// HashInput returns a stable hash of a step's input, so a resumed run can
+// tell whether the step would now be sent with different settings
+func HashInput(input any) string {
+	data, err := json.Marshal(input)
+	if err != nil {
+		// Inputs are plain structs and SDK models, this cannot fail in practice
+		data = []byte(fmt.Sprintf("%#v", input))
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strconv"
+)
+
+// DefaultTunnelCIDR is the VPN client network when TUNNEL_CIDR is not set
+const DefaultTunnelCIDR = "10.8.0.0/24"
+
+// DefaultNATInterface is the NIC of the Azure Ubuntu images, which VPN
+// traffic is masqueraded behind
+const DefaultNATInterface = "eth0"
+
+// maxTunnelBits leaves room for the server and at least one client
+const maxTunnelBits = 30
+
+var interfacePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,15}$`)
+
+// VPNPort returns VPN_PORT, or the profile's port when it is not set
+func (c *Config) VPNPort(profile Profile) int {
+	if port, err := strconv.Atoi(c.VPNPortOverride); err == nil {
+		return port
+	}
+	return profile.VPNPort
+}
+
+// TunnelPrefix returns TUNNEL_CIDR or the default. Validate has checked it.
+func (c *Config) TunnelPrefix() netip.Prefix {
+	prefix, _ := netip.ParsePrefix(orDefault(c.TunnelCIDR, DefaultTunnelCIDR))
+	return prefix
+}
+
+// ServerTunnelAddress returns the server's address in the tunnel, the first
+// host of TUNNEL_CIDR, with the tunnel's prefix length
+func (c *Config) ServerTunnelAddress() netip.Prefix {
+	tunnel := c.TunnelPrefix()
+	return netip.PrefixFrom(tunnel.Addr().Next(), tunnel.Bits())
+}
+
+// NATInterface returns NAT_INTERFACE or the default
+func (c *Config) NATInterface() string {
+	return orDefault(c.NATInterfaceName, DefaultNATInterface)
+}
+
+// natRules returns the iptables rules that forward the traffic of the tunnel
+// interface and masquerade it behind NAT_INTERFACE
+func (c *Config) natRules(tunnelInterface string) []string {
+	return []string{
+		fmt.Sprintf("FORWARD -i %s -j ACCEPT", tunnelInterface),
+		fmt.Sprintf("FORWARD -o %s -j ACCEPT", tunnelInterface),
+		fmt.Sprintf("POSTROUTING -t nat -s %s -o %s -j MASQUERADE", c.TunnelPrefix(), c.NATInterface()),
+	}
+}
+
+// validateVPN checks the VPN server settings
+func (c *Config) validateVPN() []string {
+	var problems []string
+	if c.VPNPortOverride != "" {
+		if port, err := strconv.Atoi(c.VPNPortOverride); err != nil || port < 1 || port > 65535 {
+			problems = append(problems, fmt.Sprintf("VPN_PORT %q is not a port between 1 and 65535", c.VPNPortOverride))
+		}
+	}
+
+	// The default tunnel is checked too, it could clash with the vnet
+	tunnel, tunnelErr := parsePrefix("TUNNEL_CIDR", orDefault(c.TunnelCIDR, DefaultTunnelCIDR))
+	switch {
+	case tunnelErr != "":
+		problems = append(problems, tunnelErr)
+	case !tunnel.Addr().Is4() || tunnel.Bits() > maxTunnelBits:
+		problems = append(problems, fmt.Sprintf("TUNNEL_CIDR %s is not an IPv4 CIDR of /%d or larger", tunnel, maxTunnelBits))
+	default:
+		if vnet, err := netip.ParsePrefix(c.AddressPrefix); err == nil && vnet.Overlaps(tunnel) {
+			problems = append(problems, fmt.Sprintf("TUNNEL_CIDR %s overlaps ADDRESS_PREFIX %s, set TUNNEL_CIDR to a free range", tunnel, vnet))
+		}
+	}
+
+	if c.NATInterfaceName != "" && !interfacePattern.MatchString(c.NATInterfaceName) {
+		problems = append(problems, fmt.Sprintf("NAT_INTERFACE %q is not a network interface name", c.NATInterfaceName))
+	}
+	return problems
+}
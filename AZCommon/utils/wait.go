@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/resources/armresources"
+)
+
+// DefaultDeleteTimeout is used when DELETE_TIMEOUT is not set
+const DefaultDeleteTimeout = 10 * time.Minute
+
+// WaitOptions controls how WaitFor polls
+type WaitOptions struct {
+	// Timeout is the total time to wait before giving up
+	Timeout time.Duration
+	// InitialDelay is the first delay between checks, it doubles after every
+	// check up to MaxDelay
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// DeleteWaitOptions returns the options used to wait for deletions, honoring
+// DELETE_TIMEOUT
+func (c *Config) DeleteWaitOptions() WaitOptions {
+	timeout := DefaultDeleteTimeout
+	if c.DeleteTimeout != "" {
+		// Validate already rejected unparsable values
+		if parsed, err := time.ParseDuration(c.DeleteTimeout); err == nil {
+			timeout = parsed
+		}
+	}
+	return WaitOptions{
+		Timeout:      timeout,
+		InitialDelay: 2 * time.Second,
+		MaxDelay:     30 * time.Second,
+	}
+}
+
+// WaitFor calls check with exponential backoff until it reports done, returns
+// an error, the timeout expires or ctx is cancelled. what describes the
+// condition for log and error messages.
+func WaitFor(ctx context.Context, what string, opts WaitOptions, check func(ctx context.Context) (bool, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	delay := opts.InitialDelay
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		done, err := check(ctx)
+		if err != nil {
+			return fmt.Errorf("failed while waiting for %s: %v", what, err)
+		}
+		if done {
+			InfoLogger.Printf("Done waiting for %s after %s", what, time.Since(start).Round(time.Second))
+			return nil
+		}
+
+		InfoLogger.Printf("Still waiting for %s (attempt %d), checking again in %s", what, attempt, delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("timed out after %s waiting for %s", opts.Timeout, what)
+			}
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if delay > opts.MaxDelay {
+			delay = opts.MaxDelay
+		}
+	}
+}
+
+// WaitForResourceGroupDeletion polls CheckExistence until the configured
+// resource group is really gone
+func WaitForResourceGroupDeletion(ctx context.Context, cred azcore.TokenCredential, cfg *Config, opts WaitOptions) error {
+	groupsClient, err := armresources.NewResourceGroupsClient(cfg.SubscriptionID, cred, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create resource groups client: %v", err)
+	}
+
+	what := fmt.Sprintf("resource group %s to be deleted", cfg.ResourceGroupName)
+	return WaitFor(ctx, what, opts, func(ctx context.Context) (bool, error) {
+		exists, err := groupsClient.CheckExistence(ctx, cfg.ResourceGroupName, nil)
+		if err != nil {
+			return false, err
+		}
+		return !exists.Success, nil
+	})
+}
+
+// IsConflict reports whether err is an Azure 409 response, which Azure
+// returns while a resource with the same name is still being deleted
+func IsConflict(err error) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusConflict
+}
@@ -0,0 +1,299 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// wireGuardKeepalive keeps NAT mappings open for clients behind a router
+const wireGuardKeepalive = 25
+
+// wireGuardPeer is a client as recorded in peers.json, keys included so its
+// config can be rendered again
+type wireGuardPeer struct {
+	Name string `json:"name"`
+	// Address is the client's tunnel address as a /32
+	Address string `json:"address"`
+	WireGuardKey
+	PresharedKey string    `json:"presharedKey,omitempty"`
+	DNS          []string  `json:"dns,omitempty"`
+	AllowedIPs   []string  `json:"allowedIps"`
+	Endpoint     string    `json:"endpoint"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+type wireGuardPeerFile struct {
+	Peers []wireGuardPeer `json:"peers"`
+}
+
+// WireGuardPeers is the PeerManager of the WireGuard flavor. Clients are kept
+// in wireguard/<resource group>/peers.json and their configs in clients/.
+type WireGuardPeers struct{}
+
+func loadWireGuardPeers(cfg *Config) ([]wireGuardPeer, error) {
+	path := wireGuardPath(cfg, "peers.json")
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WireGuard peers: %v", err)
+	}
+	var file wireGuardPeerFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return file.Peers, nil
+}
+
+func saveWireGuardPeers(cfg *Config, peers []wireGuardPeer) error {
+	return writeSecret(wireGuardPath(cfg, "peers.json"), wireGuardPeerFile{Peers: peers})
+}
+
+// clientConfPath returns where a client's config is written
+func clientConfPath(cfg *Config, name string) string {
+	return wireGuardPath(cfg, filepath.Join("clients", name+".conf"))
+}
+
+// Add creates a client with the next free tunnel address and fresh keys
+func (WireGuardPeers) Add(cfg *Config, profile Profile, name string, opts PeerOptions) (*ClientProfile, error) {
+	if err := ValidatePeerName(name); err != nil {
+		return nil, err
+	}
+	peers, err := loadWireGuardPeers(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, peer := range peers {
+		if strings.EqualFold(peer.Name, name) {
+			return nil, fmt.Errorf("peer %s already exists, remove it first to replace it", peer.Name)
+		}
+	}
+
+	allowedIPs := []string{anyIPv4, anyIPv6}
+	if len(opts.SplitTunnel) > 0 {
+		allowedIPs = []string{cfg.TunnelPrefix().String()}
+		for _, cidr := range opts.SplitTunnel {
+			if _, problem := parsePrefix("split tunnel CIDR", cidr); problem != "" {
+				return nil, errors.New(problem)
+			}
+			allowedIPs = append(allowedIPs, cidr)
+		}
+	}
+	dns := opts.DNS
+	if len(dns) == 0 {
+		dns = cfg.ClientDNS()
+	}
+	for _, server := range dns {
+		if _, err := netip.ParseAddr(server); err != nil {
+			return nil, fmt.Errorf("DNS server %q is not an IP address", server)
+		}
+	}
+
+	server, err := LoadWireGuardServer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	address, err := nextPeerAddress(cfg, peers)
+	if err != nil {
+		return nil, err
+	}
+	key, err := GenerateWireGuardKey()
+	if err != nil {
+		return nil, err
+	}
+	peer := wireGuardPeer{
+		Name:         name,
+		Address:      netip.PrefixFrom(address, address.BitLen()).String(),
+		WireGuardKey: key,
+		DNS:          dns,
+		AllowedIPs:   allowedIPs,
+		Endpoint:     opts.Endpoint,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if opts.PresharedKey {
+		psk := make([]byte, 32)
+		if _, err := rand.Read(psk); err != nil {
+			return nil, fmt.Errorf("failed to generate preshared key: %v", err)
+		}
+		peer.PresharedKey = base64.StdEncoding.EncodeToString(psk)
+	}
+
+	client := &ClientProfile{
+		Name:    name,
+		Path:    clientConfPath(cfg, name),
+		Content: []byte(wireGuardClientConf(server, peer)),
+	}
+	if err := writeSecretFile(client.Path, client.Content); err != nil {
+		return nil, err
+	}
+	if err := saveWireGuardPeers(cfg, append(peers, peer)); err != nil {
+		return nil, err
+	}
+	InfoLogger.Printf("Added peer %s with address %s", name, peer.Address)
+	return client, nil
+}
+
+// nextPeerAddress returns the lowest tunnel address not held by the server
+// or a client, skipping the broadcast address
+func nextPeerAddress(cfg *Config, peers []wireGuardPeer) (netip.Addr, error) {
+	tunnel := cfg.TunnelPrefix()
+	taken := map[netip.Addr]bool{cfg.ServerTunnelAddress().Addr(): true}
+	for _, peer := range peers {
+		if prefix, err := netip.ParsePrefix(peer.Address); err == nil {
+			taken[prefix.Addr()] = true
+		}
+	}
+	for addr := tunnel.Addr().Next(); tunnel.Contains(addr.Next()); addr = addr.Next() {
+		if !taken[addr] {
+			return addr, nil
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("no free address left in TUNNEL_CIDR %s", tunnel)
+}
+
+// Client renders the config of a recorded client again
+func (WireGuardPeers) Client(cfg *Config, name string) (*ClientProfile, error) {
+	peers, err := loadWireGuardPeers(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, peer := range peers {
+		if !strings.EqualFold(peer.Name, name) {
+			continue
+		}
+		server, err := LoadWireGuardServer(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &ClientProfile{
+			Name:    peer.Name,
+			Path:    clientConfPath(cfg, peer.Name),
+			Content: []byte(wireGuardClientConf(server, peer)),
+		}, nil
+	}
+	return nil, fmt.Errorf("no peer called %s", name)
+}
+
+// Remove forgets a client and deletes its config and QR code
+func (WireGuardPeers) Remove(cfg *Config, name string) error {
+	peers, err := loadWireGuardPeers(cfg)
+	if err != nil {
+		return err
+	}
+	for i, peer := range peers {
+		if !strings.EqualFold(peer.Name, name) {
+			continue
+		}
+		client := ClientProfile{Path: clientConfPath(cfg, peer.Name)}
+		for _, path := range []string{client.Path, client.QRCodePath()} {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to remove %s of peer %s: %v", path, peer.Name, err)
+			}
+		}
+		InfoLogger.Printf("Removed peer %s with address %s", peer.Name, peer.Address)
+		return saveWireGuardPeers(cfg, append(peers[:i], peers[i+1:]...))
+	}
+	return fmt.Errorf("no peer called %s", name)
+}
+
+// List returns the recorded clients
+func (WireGuardPeers) List(cfg *Config) ([]PeerInfo, error) {
+	peers, err := loadWireGuardPeers(cfg)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]PeerInfo, 0, len(peers))
+	for _, peer := range peers {
+		infos = append(infos, PeerInfo{
+			Name:         peer.Name,
+			Address:      peer.Address,
+			PublicKey:    peer.PublicKey,
+			PresharedKey: peer.PresharedKey != "",
+			SplitTunnel:  !slices.Contains(peer.AllowedIPs, anyIPv4),
+			CreatedAt:    peer.CreatedAt,
+		})
+	}
+	return infos, nil
+}
+
+// Sync rewrites the [Peer] sections of wg0.conf on the server and reloads
+// them without dropping the connected clients. The [Interface] section
+// written by cloud-init is kept, so the server key is not sent again.
+func (WireGuardPeers) Sync(ctx context.Context, cred azcore.TokenCredential, cfg *Config, profile Profile) error {
+	peers, err := loadWireGuardPeers(cfg)
+	if err != nil {
+		return err
+	}
+	conf := "/etc/wireguard/" + wireGuardInterface + ".conf"
+	script := strings.Join([]string{
+		"umask 077",
+		fmt.Sprintf(`sed '/^\[Peer\]/,$d' %s > %s.new`, conf, conf),
+		fmt.Sprintf("cat >> %s.new <<'PEERS'", conf),
+		strings.TrimRight(wireGuardServerPeers(peers), "\n"),
+		"PEERS",
+		fmt.Sprintf("mv %s.new %s", conf, conf),
+		fmt.Sprintf("if systemctl is-active --quiet wg-quick@%s; then", wireGuardInterface),
+		fmt.Sprintf("  wg-quick strip %s > /run/%s.stripped", wireGuardInterface, wireGuardInterface),
+		fmt.Sprintf("  wg syncconf %s /run/%s.stripped", wireGuardInterface, wireGuardInterface),
+		fmt.Sprintf("  rm -f /run/%s.stripped", wireGuardInterface),
+		"else",
+		fmt.Sprintf("  systemctl restart wg-quick@%s", wireGuardInterface),
+		"fi",
+	}, "\n")
+	if _, err := RunShellScript(ctx, cred, cfg, script); err != nil {
+		return err
+	}
+	InfoLogger.Printf("Server %s now has %d peers", cfg.VMName, len(peers))
+	return nil
+}
+
+// wireGuardServerPeers renders the [Peer] sections of the server's wg0.conf
+func wireGuardServerPeers(peers []wireGuardPeer) string {
+	var b strings.Builder
+	for _, peer := range peers {
+		fmt.Fprintln(&b)
+		fmt.Fprintln(&b, "[Peer]")
+		fmt.Fprintf(&b, "# %s\n", peer.Name)
+		fmt.Fprintf(&b, "PublicKey = %s\n", peer.PublicKey)
+		if peer.PresharedKey != "" {
+			fmt.Fprintf(&b, "PresharedKey = %s\n", peer.PresharedKey)
+		}
+		fmt.Fprintf(&b, "AllowedIPs = %s\n", peer.Address)
+	}
+	return b.String()
+}
+
+// wireGuardClientConf renders the config file a client imports
+func wireGuardClientConf(server *WireGuardServer, peer wireGuardPeer) string {
+	var b strings.Builder
+	fmt.Fprintln(&b, "[Interface]")
+	fmt.Fprintf(&b, "# %s\n", peer.Name)
+	fmt.Fprintf(&b, "PrivateKey = %s\n", peer.PrivateKey)
+	fmt.Fprintf(&b, "Address = %s\n", peer.Address)
+	if len(peer.DNS) > 0 {
+		fmt.Fprintf(&b, "DNS = %s\n", strings.Join(peer.DNS, ", "))
+	}
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "[Peer]")
+	fmt.Fprintf(&b, "PublicKey = %s\n", server.PublicKey)
+	if peer.PresharedKey != "" {
+		fmt.Fprintf(&b, "PresharedKey = %s\n", peer.PresharedKey)
+	}
+	fmt.Fprintf(&b, "Endpoint = %s\n", peer.Endpoint)
+	fmt.Fprintf(&b, "AllowedIPs = %s\n", strings.Join(peer.AllowedIPs, ", "))
+	fmt.Fprintf(&b, "PersistentKeepalive = %d\n", wireGuardKeepalive)
+	return b.String()
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNextPeerAddress(t *testing.T) {
+	tests := []struct {
+		name   string
+		tunnel string
+		peers  []string
+		want   string
+		// wantErr is part of the expected error, set when the tunnel is full
+		wantErr string
+	}{
+		{
+			name: "first client after the server",
+			want: "10.8.0.2",
+		},
+		{
+			name:  "next after the recorded clients",
+			peers: []string{"10.8.0.2/32", "10.8.0.3/32"},
+			want:  "10.8.0.4",
+		},
+		{
+			name:  "gap left by a removed client is reused",
+			peers: []string{"10.8.0.2/32", "10.8.0.4/32", "10.8.0.5/32"},
+			want:  "10.8.0.3",
+		},
+		{
+			name:   "custom tunnel",
+			tunnel: "172.16.4.0/24",
+			peers:  []string{"172.16.4.2/32"},
+			want:   "172.16.4.3",
+		},
+		{
+			name:   "last address before the broadcast",
+			tunnel: "10.8.0.0/29",
+			peers:  []string{"10.8.0.2/32", "10.8.0.3/32", "10.8.0.4/32", "10.8.0.5/32"},
+			want:   "10.8.0.6",
+		},
+		{
+			name:    "broadcast address is never handed out",
+			tunnel:  "10.8.0.0/29",
+			peers:   []string{"10.8.0.2/32", "10.8.0.3/32", "10.8.0.4/32", "10.8.0.5/32", "10.8.0.6/32"},
+			wantErr: "no free address left in TUNNEL_CIDR 10.8.0.0/29",
+		},
+		{
+			name:   "single client of a /30",
+			tunnel: "10.8.0.0/30",
+			want:   "10.8.0.2",
+		},
+		{
+			name:    "full /30",
+			tunnel:  "10.8.0.0/30",
+			peers:   []string{"10.8.0.2/32"},
+			wantErr: "no free address left in TUNNEL_CIDR 10.8.0.0/30",
+		},
+		{
+			name:   "client outside a shrunk tunnel holds nothing",
+			tunnel: "10.8.0.0/30",
+			peers:  []string{"10.8.0.9/32"},
+			want:   "10.8.0.2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{TunnelCIDR: tt.tunnel}
+			var peers []wireGuardPeer
+			for _, address := range tt.peers {
+				peers = append(peers, wireGuardPeer{Name: address, Address: address})
+			}
+
+			got, err := nextPeerAddress(cfg, peers)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got %v, %v, want error %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package utils
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WireGuardDir is where the WireGuard keys are kept, one directory per
+// resource group
+const WireGuardDir = "wireguard"
+
+// wireGuardInterface is the tunnel interface on the server, managed by
+// wg-quick@wg0
+const wireGuardInterface = "wg0"
+
+// WireGuardKey is a Curve25519 key pair, base64 encoded as wg expects
+type WireGuardKey struct {
+	PrivateKey string `json:"privateKey"`
+	PublicKey  string `json:"publicKey"`
+}
+
+// GenerateWireGuardKey creates a new key pair, like "wg genkey | wg pubkey"
+func GenerateWireGuardKey() (WireGuardKey, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return WireGuardKey{}, fmt.Errorf("failed to generate WireGuard key: %v", err)
+	}
+	return WireGuardKey{
+		PrivateKey: base64.StdEncoding.EncodeToString(key.Bytes()),
+		PublicKey:  base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()),
+	}, nil
+}
+
+// WireGuardServer is the server's identity, generated locally on first use
+// and kept so that every deploy and client config agree on it
+type WireGuardServer struct {
+	WireGuardKey
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// wireGuardPath returns a file in the WireGuard directory of the deployment
+func wireGuardPath(cfg *Config, name string) string {
+	return filepath.Join(WireGuardDir, cfg.ResourceGroupName, name)
+}
+
+// LoadWireGuardServer reads the server key of the deployment, generating and
+// saving it the first time. The file holds the private key and is only
+// readable by the owner.
+func LoadWireGuardServer(cfg *Config) (*WireGuardServer, error) {
+	path := wireGuardPath(cfg, "server.json")
+	server, err := readWireGuardServer(path)
+	if err != nil || server != nil {
+		return server, err
+	}
+
+	key, err := GenerateWireGuardKey()
+	if err != nil {
+		return nil, err
+	}
+	server = &WireGuardServer{WireGuardKey: key, CreatedAt: time.Now().UTC()}
+	if err := writeSecret(path, server); err != nil {
+		return nil, err
+	}
+	InfoLogger.Printf("Generated WireGuard server key, saved to %s", path)
+	return server, nil
+}
+
+// readWireGuardServer reads a saved server key, nil when there is none yet
+func readWireGuardServer(path string) (*WireGuardServer, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WireGuard server key: %v", err)
+	}
+	server := &WireGuardServer{}
+	if err := json.Unmarshal(data, server); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return server, nil
+}
+
+// plannedSecret stands in for a key that deploy generates, in the cloud-init
+// of a plan made before the first deploy
+const plannedSecret = "<generated on deploy>"
+
+// planWireGuardServer reads the server key for a plan, with placeholders
+// instead of generating one when there is none yet
+func planWireGuardServer(cfg *Config) (*WireGuardServer, error) {
+	path := wireGuardPath(cfg, "server.json")
+	server, err := readWireGuardServer(path)
+	if err != nil || server != nil {
+		return server, err
+	}
+	InfoLogger.Printf("No WireGuard server key in %s yet, the plan uses a placeholder for the key deploy generates", path)
+	return &WireGuardServer{WireGuardKey: WireGuardKey{PrivateKey: plannedSecret, PublicKey: plannedSecret}}, nil
+}
+
+// writeSecret saves v as JSON in a file only the owner can read
+func writeSecret(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeSecretFile(path, data)
+}
+
+// writeSecretFile saves data in a file only the owner can read
+func writeSecretFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %v", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// WireGuardServerConf renders the server's wg0.conf. Traffic from the
+// tunnel is forwarded and masqueraded behind NAT_INTERFACE while the
+// interface is up.
+func WireGuardServerConf(cfg *Config, profile Profile, server *WireGuardServer) string {
+	rules := cfg.natRules("%i")
+	iptables := func(action string) string {
+		var commands []string
+		for _, rule := range rules {
+			commands = append(commands, fmt.Sprintf("iptables %s %s", action, rule))
+		}
+		return strings.Join(commands, "; ")
+	}
+
+	var b strings.Builder
+	fmt.Fprintln(&b, "[Interface]")
+	fmt.Fprintf(&b, "Address = %s\n", cfg.ServerTunnelAddress())
+	fmt.Fprintf(&b, "ListenPort = %d\n", cfg.VPNPort(profile))
+	fmt.Fprintf(&b, "PrivateKey = %s\n", server.PrivateKey)
+	fmt.Fprintf(&b, "PostUp = %s\n", iptables("-A"))
+	fmt.Fprintf(&b, "PostDown = %s\n", iptables("-D"))
+	return b.String()
+}
+
+// WireGuardCloudInit generates the cloud-init that installs WireGuard, writes
+// wg0.conf, turns on IP forwarding and enables wg-quick@wg0, so the server
+// is up on first boot. A dry run never generates the server key.
+func WireGuardCloudInit(cfg *Config, profile Profile, dryRun bool) (*CloudConfig, error) {
+	load := LoadWireGuardServer
+	if dryRun {
+		load = planWireGuardServer
+	}
+	server, err := load(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &CloudConfig{
+		PackageUpdate: true,
+		Packages:      []string{"wireguard", "iptables"},
+		WriteFiles: []WriteFile{
+			{
+				Path:        "/etc/wireguard/" + wireGuardInterface + ".conf",
+				Content:     WireGuardServerConf(cfg, profile, server),
+				Owner:       "root:root",
+				Permissions: "0600",
+			},
+			{
+				Path:        "/etc/sysctl.d/99-wireguard.conf",
+				Content:     "net.ipv4.ip_forward = 1\n",
+				Permissions: "0644",
+			},
+		},
+		RunCmd: []string{
+			"sysctl --system",
+			"systemctl enable --now wg-quick@" + wireGuardInterface,
+		},
+	}, nil
+}
+
+// WireGuardServerInfo describes the server for the end of deploy
+func WireGuardServerInfo(cfg *Config, profile Profile) (string, error) {
+	server, err := LoadWireGuardServer(cfg)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("WireGuard server public key: %s\nWireGuard tunnel: %s\n", server.PublicKey, cfg.ServerTunnelAddress()), nil
+}